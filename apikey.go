@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Name under which uploads without an API key are logged
+const anonymousUploader = "anonymous"
+
+// API keys are sent as "Authorization: Bearer <secret>"
+const bearerPrefix = "Bearer "
+
+// Form field with which uploaders permitted to do so can override their default scrub policy
+const scrubFormField = "scrub"
+
+// Determines whether uploads made with an API key are scrubbed
+type ScrubPolicy string
+
+const (
+	// Uploads are always scrubbed, the "scrub" form field is ignored
+	ScrubPolicyAlways ScrubPolicy = "always"
+	// Uploads are scrubbed unless the request sets "scrub=false"
+	ScrubPolicyOptOut ScrubPolicy = "optout"
+	// Uploads are stored as-is unless the request sets "scrub=true"
+	ScrubPolicyOptIn ScrubPolicy = "optin"
+)
+
+func ParseScrubPolicy(s string) (ScrubPolicy, error) {
+	switch policy := ScrubPolicy(s); policy {
+	case ScrubPolicyAlways, ScrubPolicyOptOut, ScrubPolicyOptIn:
+		return policy, nil
+	default:
+		return "", errors.Errorf(
+			"unknown scrub policy: \"%s\" (expected \"%s\", \"%s\" or \"%s\")",
+			s,
+			ScrubPolicyAlways,
+			ScrubPolicyOptOut,
+			ScrubPolicyOptIn,
+		)
+	}
+}
+
+type ApiKey struct {
+	// Used in logs instead of the secret
+	Name        string
+	Secret      string
+	ScrubPolicy ScrubPolicy
+}
+
+// Parses the value of an "ApiKey" config line, i.e., "<name> <secret> <scrub policy>"
+func parseApiKey(val string) (ApiKey, error) {
+	fields := strings.Fields(val)
+	if len(fields) != 3 {
+		return ApiKey{}, errors.Errorf(
+			"ApiKey must consist of a name, a secret and a scrub policy, got \"%s\"",
+			val,
+		)
+	}
+
+	policy, err := ParseScrubPolicy(fields[2])
+	if err != nil {
+		return ApiKey{}, err
+	}
+
+	return ApiKey{
+		Name:        fields[0],
+		Secret:      fields[1],
+		ScrubPolicy: policy,
+	}, nil
+}
+
+// Builds an API key from a table of a structured config file with the keys "name", "secret" and
+// "scrub_policy". Unlike "ApiKey" lines, tables allow secrets containing spaces.
+func apiKeyFromTable(table map[string]any) (ApiKey, error) {
+	vals := make(map[string]string, len(table))
+	for key, val := range table {
+		switch key {
+		case "name", "secret", "scrub_policy":
+		default:
+			return ApiKey{}, errors.Errorf("unknown API key setting: \"%s\"", key)
+		}
+
+		str, ok := val.(string)
+		if !ok || str == "" {
+			return ApiKey{}, errors.Errorf("API key setting \"%s\" must be a non-empty string", key)
+		}
+
+		vals[key] = str
+	}
+
+	for _, key := range []string{"name", "secret", "scrub_policy"} {
+		if _, found := vals[key]; !found {
+			return ApiKey{}, errors.Errorf("API key is missing \"%s\"", key)
+		}
+	}
+
+	policy, err := ParseScrubPolicy(vals["scrub_policy"])
+	if err != nil {
+		return ApiKey{}, err
+	}
+
+	return ApiKey{
+		Name:        vals["name"],
+		Secret:      vals["secret"],
+		ScrubPolicy: policy,
+	}, nil
+}
+
+// Adds `apiKey` to the config unless its name or secret is already taken
+func (config *Config) addApiKey(apiKey ApiKey) error {
+	for _, existing := range config.ApiKeys {
+		if existing.Name == apiKey.Name || existing.Secret == apiKey.Secret {
+			return errors.Errorf("duplicate API key: \"%s\"", apiKey.Name)
+		}
+	}
+
+	config.ApiKeys = append(config.ApiKeys, apiKey)
+	return nil
+}
+
+// Returns the configured API key matching `secret` or nil if there is none
+func (config *Config) findApiKey(secret string) *ApiKey {
+	var found *ApiKey
+	for i := range config.ApiKeys {
+		// Compare against all keys in constant time to not leak which prefix matched
+		if subtle.ConstantTimeCompare([]byte(config.ApiKeys[i].Secret), []byte(secret)) == 1 {
+			found = &config.ApiKeys[i]
+		}
+	}
+
+	return found
+}
+
+// Returns the API key a request was made with, nil for requests without a bearer token and an
+// error if the request carries a key that is not configured or lacks a required key
+func (config *Config) apiKeyOf(r *http.Request) (*ApiKey, error) {
+	// Other schemes, e.g., basic authentication handled by a reverse proxy, are not ours to check
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		if config.RequireApiKey {
+			return nil, errors.New("an API key is required")
+		}
+
+		return nil, nil
+	}
+
+	secret := strings.TrimSpace(strings.TrimPrefix(authorization, bearerPrefix))
+	apiKey := config.findApiKey(secret)
+	if apiKey == nil {
+		return nil, errors.New("unknown API key")
+	}
+
+	return apiKey, nil
+}
+
+// Decides whether an upload is scrubbed. Anonymous uploads follow the global ScrubExif setting,
+// uploads with an API key follow the key's scrub policy. The "scrub" form field is only honored
+// for keys that permit overriding their default. Returns the decision along with a reason for
+// the upload log.
+func (config *Config) scrubDecision(apiKey *ApiKey, r *http.Request) (bool, string, error) {
+	requested := r.FormValue(scrubFormField)
+
+	var override *bool
+	if requested != "" {
+		parsed, err := strconv.ParseBool(requested)
+		if err != nil {
+			return false, "", errors.Errorf("invalid value for \"%s\": \"%s\"", scrubFormField, requested)
+		}
+
+		override = &parsed
+	}
+
+	if apiKey == nil {
+		reason := "global setting"
+		if override != nil {
+			reason += ", ignoring request without API key"
+		}
+
+		return config.ScrubExif, reason, nil
+	}
+
+	switch apiKey.ScrubPolicy {
+	case ScrubPolicyOptOut, ScrubPolicyOptIn:
+		if override != nil {
+			return *override, "requested by uploader", nil
+		}
+
+		return apiKey.ScrubPolicy == ScrubPolicyOptOut, "default of API key", nil
+	default:
+		reason := "API key always scrubs"
+		if override != nil {
+			reason += ", ignoring request"
+		}
+
+		return true, reason, nil
+	}
+}
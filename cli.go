@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+// Suffix of the temporary files written by writeFileAtomically
+const tempFileSuffix = ".tmp"
+
+// A file or directory to scrub along with the config to scrub it with
+type scrubTarget struct {
+	path   string
+	config *Config
+	// Whether `path` is the FileDir of a profile. Only the files directly in it are scrubbed, since
+	// the FileDirs of other profiles may be nested in it.
+	uploadDir bool
+}
+
+// Scrubs the files and directories given as arguments (or the FileDirs of the config and its
+// profiles) with the scrubber described by the config file. Files are overwritten in place unless
+// an output directory is given.
+func runScrub(params *parameters) error {
+	config, err := params.loadConfig(params.scrubUploads && !params.dryRun)
+	if err != nil {
+		return err
+	}
+
+	// Files given as arguments are scrubbed with the base config, uploads with the config of the
+	// profile they were uploaded to
+	targets := make([]scrubTarget, 0, len(params.args))
+	for _, arg := range params.args {
+		targets = append(targets, scrubTarget{path: arg, config: config})
+	}
+
+	if params.scrubUploads {
+		targets = append(targets, uploadTargets(config, params.includeOriginals)...)
+	} else if len(targets) == 0 {
+		return errors.New("no files to scrub given")
+	}
+
+	if params.outDir != "" {
+		if err := os.MkdirAll(params.outDir, 0o755); err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+	}
+
+	// Output paths and the files they were written for, to catch files that would overwrite each
+	// other, e.g., "a/img.jpg" and "b/img.jpg" given as arguments
+	outPaths := make(map[string]string)
+
+	failed := 0
+	for _, target := range targets {
+		scrubber := newExifScrubber(target.config)
+		visit := func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Temporary files are still being written by the server
+			if entry.IsDir() || isTempFileName(entry.Name()) {
+				return nil
+			}
+
+			outPath := path
+			if params.outDir != "" {
+				outPath = outPathOf(params.outDir, target.path, path)
+				if existing, found := outPaths[outPath]; found {
+					fmt.Fprintf(
+						os.Stderr,
+						"%s: would overwrite the result of %s in %s\n",
+						path,
+						existing,
+						outPath,
+					)
+					failed++
+					return nil
+				}
+				outPaths[outPath] = path
+			}
+
+			if err := scrubFile(target.config, scrubber, path, outPath, params); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+				failed++
+			}
+			return nil
+		}
+
+		if target.uploadDir {
+			err = walkTopLevel(target.path, visit)
+		} else {
+			err = filepath.WalkDir(target.path, visit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", target.path, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("could not scrub %d file(s)", failed)
+	}
+	return nil
+}
+
+// Returns the FileDirs of `config` and its profiles along with the config of the profile that
+// uploads to each of them. Directories that may hold files that were stored unscrubbed on purpose
+// are skipped unless `includeOriginals` is set.
+func uploadTargets(config *Config, includeOriginals bool) []scrubTarget {
+	configs := []*Config{config}
+	for _, profile := range config.Profiles {
+		configs = append(configs, profile.Config)
+	}
+
+	// Several profiles may share a FileDir, it is scrubbed with the config listed first
+	targets := []scrubTarget{}
+	reasons := make(map[string]string)
+	for _, uploadConfig := range configs {
+		reason, found := reasons[uploadConfig.FileDir]
+		if !found {
+			targets = append(targets, scrubTarget{
+				path:      uploadConfig.FileDir,
+				config:    uploadConfig,
+				uploadDir: true,
+			})
+		}
+
+		if reason == "" {
+			reasons[uploadConfig.FileDir] = originalsReason(uploadConfig)
+		}
+	}
+
+	kept := []scrubTarget{}
+	for _, target := range targets {
+		if reason := reasons[target.path]; reason != "" && !includeOriginals {
+			fmt.Printf("%s: skipped, %s (pass -include-originals to scrub it anyway)\n",
+				target.path, reason)
+			continue
+		}
+		kept = append(kept, target)
+	}
+
+	return kept
+}
+
+// Returns why uploads stored with `config` may have been kept unscrubbed on purpose, or an empty
+// string if all of them have been scrubbed
+func originalsReason(config *Config) string {
+	if !config.ScrubExif {
+		return "ScrubExif is disabled"
+	}
+
+	for _, apiKey := range config.ApiKeys {
+		if apiKey.ScrubPolicy != ScrubPolicyAlways {
+			return fmt.Sprintf("API key %s keeps originals (%s)", apiKey.Name, apiKey.ScrubPolicy)
+		}
+	}
+
+	return ""
+}
+
+// Calls `visit` for every entry directly in `dir`, without descending into subdirectories
+func walkTopLevel(dir string, visit fs.WalkDirFunc) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := visit(filepath.Join(dir, entry.Name()), entry, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns where the result of scrubbing `path`, found while walking `root`, is written to below
+// `outDir`. The directory structure below `root` is kept; files given directly end up right in
+// `outDir`.
+func outPathOf(outDir string, root string, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		rel = filepath.Base(path)
+	}
+
+	return filepath.Join(outDir, rel)
+}
+
+// Scrubs the file at `path` and writes the result to `outPath`, which may be `path` itself
+func scrubFile(
+	config *Config,
+	scrubber *exifscrubber.ExifScrubber,
+	path string,
+	outPath string,
+	params *parameters,
+) error {
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scrubbedData, report, err := scrubFileData(config, scrubber, fileData)
+	if err == exifscrubber.ErrUnknownFileType {
+		fmt.Printf("%s: skipped, unsupported file type\n", path)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	action := "scrubbed"
+	if params.dryRun {
+		action = "would scrub"
+	}
+
+	if report != nil {
+		fmt.Printf("%s: %s, removed %d tag(s), kept %d tag(s)\n",
+			path, action, report.RemovedCount(), report.KeptCount())
+	} else {
+		fmt.Printf("%s: %s, stripped all metadata\n", path, action)
+	}
+
+	if params.dryRun {
+		return nil
+	}
+
+	if outPath == path && bytes.Equal(scrubbedData, fileData) {
+		// Nothing changed, no need to touch the file
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return err
+	}
+
+	return writeFileAtomically(outPath, scrubbedData)
+}
+
+// Writes `data` to a temporary file next to `path` and renames it over `path` afterwards so that
+// readers never see a partially written file. An existing file keeps its permissions.
+func writeFileAtomically(path string, data []byte) error {
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// Prints every EXIF tag of the given file and whether the configured scrubber would remove it.
+func runInspect(params *parameters) error {
+	if len(params.args) != 1 {
+		return errors.New("exactly one file to inspect must be given")
+	}
+
+	config, err := params.loadConfig(false)
+	if err != nil {
+		return err
+	}
+
+	path := params.args[0]
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, report, err := newExifScrubber(config).ScrubExif(fileData)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, tag := range report.Tags {
+		verdict := "kept"
+		if tag.Removed {
+			verdict = "removed"
+		} else if tag.Coarsened {
+			verdict = "coarsened"
+		}
+
+		flags := ""
+		if tag.Gps {
+			flags += " [GPS]"
+		}
+		if tag.Identifying {
+			flags += " [identifying]"
+		}
+
+		fmt.Printf("%s/%s (0x%04x): %s%s\n", tag.IfdPath, tag.TagName, tag.TagId, verdict, flags)
+	}
+
+	fmt.Printf("%d tag(s) would be removed, %d tag(s) would be kept\n",
+		report.RemovedCount(), report.KeptCount())
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrubCommand(t *testing.T) {
+	fileData, err := os.ReadFile("fixtures/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gps.jpg")
+	if err := os.WriteFile(path, fileData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run must not modify the file
+	params := &parameters{
+		configFile:    "example.conf",
+		configFileSet: true,
+		dryRun:        true,
+		args:          []string{dir},
+	}
+	if err := runScrub(params); err != nil {
+		t.Fatal(err)
+	}
+
+	dryRunData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dryRunData, fileData) {
+		t.Error("dry run modified the file")
+	}
+
+	params.dryRun = false
+	if err := runScrub(params); err != nil {
+		t.Fatal(err)
+	}
+
+	scrubbedData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(scrubbedData, fileData) {
+		t.Error("file was not scrubbed")
+	}
+
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newExifScrubber(config).Verify(scrubbedData); err != nil {
+		t.Error("scrubbed file does not verify:", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(info.Mode().Perm(), 0o600, t)
+
+	// No temporary files may be left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 1, t)
+}
+
+func TestScrubCommandOutDir(t *testing.T) {
+	fileData, err := os.ReadFile("fixtures/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, "gps.jpg"), fileData, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The directory structure below the scrubbed directory is kept
+	outDir := filepath.Join(t.TempDir(), "out")
+	params := &parameters{
+		configFile:    "example.conf",
+		configFileSet: true,
+		outDir:        outDir,
+		args:          []string{dir},
+	}
+	if err := runScrub(params); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(outDir, sub, "gps.jpg")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Files with the same name given directly must not overwrite each other
+	params.outDir = filepath.Join(t.TempDir(), "out")
+	params.args = []string{filepath.Join(dir, "a", "gps.jpg"), filepath.Join(dir, "b", "gps.jpg")}
+	if err := runScrub(params); err == nil {
+		t.Error("colliding output accepted")
+	}
+
+	entries, err := os.ReadDir(params.outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 1, t)
+}
+
+func TestScrubCommandUploads(t *testing.T) {
+	fileData, err := os.ReadFile("fixtures/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile keeps originals in a directory below the base FileDir
+	baseDir := t.TempDir() + "/"
+	profileDir := filepath.Join(baseDir, "internal") + "/"
+	if err := os.Mkdir(profileDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	example, err := os.ReadFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, "jaf.conf", string(example)+`
+FileDir: `+baseDir+`
+[profile internal]
+FileDir: `+profileDir+`
+ScrubExif: false
+`)
+
+	files := []string{
+		filepath.Join(baseDir, "abcde.jpg"),
+		// A temporary file the server is still writing
+		filepath.Join(baseDir, ".fghij.jpg.123"+tempFileSuffix),
+		filepath.Join(profileDir, "klmno.jpg"),
+	}
+	for _, file := range files {
+		if err := os.WriteFile(file, fileData, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modified := func(file string) bool {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return !bytes.Equal(data, fileData)
+	}
+
+	params := &parameters{
+		configFile:    path,
+		configFileSet: true,
+		scrubUploads:  true,
+	}
+	if err := runScrub(params); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(modified(files[0]), true, t)
+	assertEqual(modified(files[1]), false, t)
+	assertEqual(modified(files[2]), false, t)
+
+	// Originals are only scrubbed on request, and then with the settings of their profile
+	params.includeOriginals = true
+	if err := runScrub(params); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(modified(files[1]), false, t)
+	assertEqual(modified(files[2]), true, t)
+}
@@ -2,34 +2,674 @@ package main
 
 import (
 	"bufio"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/go-errors/errors"
+	"github.com/leon-richardt/jaf/exifscrubber"
 )
 
 const (
 	commentPrefix = "#"
+	// Prefix of environment variables overriding config keys, e.g., "JAF_PORT"
+	envPrefix = "JAF_"
+	// Separates multiple values of repeatable keys in environment variables
+	envListSeparator = ","
+	// Replaces API key secrets when printing the config
+	redactedSecret = "<redacted>"
+	// Starts a section such as "[profile internal]"
+	sectionPrefix = "["
 )
 
 type Config struct {
-	Port             int
-	LinkPrefix       string
-	FileDir          string
-	LinkLength       int
-	ScrubExif        bool
-	ExifAllowedIds   []uint16
-	ExifAllowedPaths []string
-	ExifAbortOnError bool
+	Listen              ListenMode
+	BindAddress         string
+	Port                int
+	SocketPath          string
+	SocketMode          os.FileMode
+	SocketOwner         string
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSRedirectPort     int
+	ReadHeaderTimeout   time.Duration
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	MaxHeaderBytes      int
+	LinkPrefix          string
+	FileDir             string
+	LinkLength          int
+	MaxUploadSize       int
+	ShutdownTimeout     time.Duration
+	ScrubExif           bool
+	ExifMode            exifscrubber.Mode
+	ExifAllowedIds      []uint16
+	ExifAllowedPaths    []string
+	ExifDeniedIds       []uint16
+	ExifDeniedPaths     []string
+	ExifCoarsenGps      bool
+	ExifGpsDecimals     int
+	ExifBakeOrientation bool
+	ExifJpegQuality     int
+	ExifKeepThumbnail   bool
+	ExifStripIccProfile bool
+	ExifStripOnError    bool
+	ExifAbortOnError    bool
+	ApiKeys             []ApiKey
+	RequireApiKey       bool
+	// Settings for uploads to other hosts or paths, see Profile
+	Profiles []Profile
+
+	// Where the value of each key was last set, e.g., "jaf.conf:3", keyed by config key. Keys set
+	// to their default are missing.
+	sources map[string]string
+	// Values that could not be applied, reported by Validate
+	problems ConfigErrors
+	// Profiles as read from the config file, turned into Profiles once all sources are applied
+	profileSpecs []*profileSpec
+}
+
+// Describes a config key and how its value is applied to a Config. All config sources (file,
+// environment and command-line flags) go through this table so they cannot drift apart.
+type configField struct {
+	// Name of the key in the config file, e.g., "LinkPrefix"
+	key string
+	// Help text of the command-line flag
+	usage string
+	// Whether the command-line flag may be given without a value
+	isBool bool
+	// Whether the value is a space-separated list, which structured config files give as an array
+	isList bool
+	// Whether the key applies to the whole server and thus cannot be set per profile
+	global bool
+	// Whether changes only take effect when jaf is restarted, not when the config is reloaded
+	restart bool
+	// Whether structured config files must give the value as a string. Set for values that YAML
+	// and TOML would read as numbers in a different base than the key expects.
+	stringOnly bool
+	// Location of the key in structured config files, e.g., "exif.scrub". Derived from `key` if
+	// empty, see structuredPath.
+	path string
+	// Parses `val` and stores it in `config`. Called once per occurrence for repeatable keys.
+	set func(config *Config, val string) error
+	// Formats the value(s) stored in `config` as they would appear in a config file, one entry per
+	// occurrence of the key. Secrets are redacted.
+	get func(config *Config) []string
+	// Only set for keys that may occur multiple times: removes all values so that a source with
+	// higher precedence replaces the values instead of adding to them
+	reset func(config *Config)
+	// Optional: stores a value given as a table in a structured config file
+	setTable func(config *Config, table map[string]any) error
+}
+
+var configFields = []configField{
+	asServerOption(configField{
+		key: "Listen",
+		usage: "`kind` of socket to listen on: \"tcp\" for BindAddress and Port, \"unix\" for " +
+			"SocketPath or \"systemd\" for a socket passed by systemd",
+		set: func(config *Config, val string) error {
+			parsed, err := ParseListenMode(val)
+			if err != nil {
+				return err
+			}
+
+			config.Listen = parsed
+			return nil
+		},
+		get: func(config *Config) []string {
+			return []string{string(config.Listen)}
+		},
+	}),
+	asServerOption(stringField(
+		"BindAddress",
+		"the IP `address` or host name jaf will listen on, empty for all interfaces",
+		func(config *Config) *string { return &config.BindAddress },
+	)),
+	asServerOption(intRangeField(
+		"Port",
+		"the `port` number jaf will listen on",
+		func(config *Config) *int { return &config.Port },
+		1,
+		65535,
+	)),
+	asServerOption(stringField(
+		"SocketPath",
+		"`path` of the Unix domain socket jaf will listen on if Listen is \"unix\"",
+		func(config *Config) *string { return &config.SocketPath },
+	)),
+	asServerOption(configField{
+		key:   "SocketMode",
+		usage: "octal permission `bits` of the Unix domain socket, e.g., 0660",
+		// YAML reads 0660 and TOML reads 0o660 as the decimal number 432
+		stringOnly: true,
+		set: func(config *Config, val string) error {
+			parsed, err := strconv.ParseUint(val, 8, 32)
+			if err != nil || parsed > 0o777 {
+				return errors.Errorf("expected octal permission bits like 0660, got \"%s\"", val)
+			}
+
+			config.SocketMode = os.FileMode(parsed)
+			return nil
+		},
+		get: func(config *Config) []string {
+			return []string{fmt.Sprintf("%04o", uint32(config.SocketMode))}
+		},
+	}),
+	asServerOption(stringField(
+		"SocketOwner",
+		"`owner` of the Unix domain socket as \"user\", \"user:group\" or \":group\", empty to "+
+			"keep the owner of the jaf process",
+		func(config *Config) *string { return &config.SocketOwner },
+	)),
+	asServerOption(stringField(
+		"TLSCertFile",
+		"`path` of the PEM certificate (chain) to serve HTTPS with, empty to serve plain HTTP",
+		func(config *Config) *string { return &config.TLSCertFile },
+	)),
+	asServerOption(stringField(
+		"TLSKeyFile",
+		"`path` of the PEM private key belonging to TLSCertFile",
+		func(config *Config) *string { return &config.TLSKeyFile },
+	)),
+	asServerOption(intRangeField(
+		"TLSRedirectPort",
+		"`port` on which to redirect plain HTTP requests to HTTPS, 0 to disable",
+		func(config *Config) *int { return &config.TLSRedirectPort },
+		0,
+		65535,
+	)),
+	asServerOption(durationField(
+		"ReadHeaderTimeout",
+		"how long clients may take to send the request headers, 0 for no limit",
+		func(config *Config) *time.Duration { return &config.ReadHeaderTimeout },
+	)),
+	asServerOption(durationField(
+		"ReadTimeout",
+		"how long clients may take to send the whole request including the upload, 0 for no limit",
+		func(config *Config) *time.Duration { return &config.ReadTimeout },
+	)),
+	asServerOption(durationField(
+		"WriteTimeout",
+		"how long handling a request including the response may take, 0 for no limit",
+		func(config *Config) *time.Duration { return &config.WriteTimeout },
+	)),
+	asServerOption(durationField(
+		"IdleTimeout",
+		"how long to keep idle connections open, 0 to use ReadTimeout",
+		func(config *Config) *time.Duration { return &config.IdleTimeout },
+	)),
+	asServerOption(intField(
+		"MaxHeaderBytes",
+		"the maximum size of request headers in `bytes`, 0 for the default of 1 MiB",
+		func(config *Config) *int { return &config.MaxHeaderBytes },
+	)),
+	stringField(
+		"LinkPrefix",
+		"a `string` that will be prepended to the generated file names",
+		func(config *Config) *string { return &config.LinkPrefix },
+	),
+	stringField(
+		"FileDir",
+		"`path` to the directory uploaded files are saved in",
+		func(config *Config) *string { return &config.FileDir },
+	),
+	intField(
+		"LinkLength",
+		"the `number` of characters of generated file names",
+		func(config *Config) *int { return &config.LinkLength },
+	),
+	intField(
+		"MaxUploadSize",
+		"the maximum size of uploads in `bytes`, 0 for no limit",
+		func(config *Config) *int { return &config.MaxUploadSize },
+	),
+	asGlobal(durationField(
+		"ShutdownTimeout",
+		"how long to wait for uploads in progress when shutting down, e.g., \"10s\"",
+		func(config *Config) *time.Duration { return &config.ShutdownTimeout },
+	)),
+	withPath("exif.scrub", boolField(
+		"ScrubExif",
+		"whether to remove EXIF tags from uploads without an API key",
+		func(config *Config) *bool { return &config.ScrubExif },
+	)),
+	{
+		key: "ExifMode",
+		usage: "EXIF scrubbing `mode`: \"allowlist\" to keep only the allowed tags, " +
+			"\"denylist\" to remove only the denied tags",
+		set: func(config *Config, val string) error {
+			parsed, err := exifscrubber.ParseMode(val)
+			if err != nil {
+				return err
+			}
+
+			config.ExifMode = parsed
+			return nil
+		},
+		get: func(config *Config) []string {
+			return []string{string(config.ExifMode)}
+		},
+	},
+	tagIdsField(
+		"ExifAllowedIds",
+		"space-separated EXIF tag `IDs` to keep in allowlist mode",
+		func(config *Config) *[]uint16 { return &config.ExifAllowedIds },
+	),
+	tagPathsField(
+		"ExifAllowedPaths",
+		"space-separated EXIF tag `paths` or presets to keep in allowlist mode",
+		func(config *Config) *[]string { return &config.ExifAllowedPaths },
+	),
+	tagIdsField(
+		"ExifDeniedIds",
+		"space-separated EXIF tag `IDs` to remove in denylist mode",
+		func(config *Config) *[]uint16 { return &config.ExifDeniedIds },
+	),
+	tagPathsField(
+		"ExifDeniedPaths",
+		"space-separated EXIF tag `paths` or presets to remove in denylist mode",
+		func(config *Config) *[]string { return &config.ExifDeniedPaths },
+	),
+	boolField(
+		"ExifCoarsenGps",
+		"whether to keep GPS coordinates with reduced precision",
+		func(config *Config) *bool { return &config.ExifCoarsenGps },
+	),
+	intRangeField(
+		"ExifGpsDecimals",
+		"the `number` of decimal places GPS coordinates are rounded to",
+		func(config *Config) *int { return &config.ExifGpsDecimals },
+		0,
+		exifscrubber.MaxGpsDecimals,
+	),
+	boolField(
+		"ExifBakeOrientation",
+		"whether to rotate images according to their EXIF orientation and remove all EXIF tags",
+		func(config *Config) *bool { return &config.ExifBakeOrientation },
+	),
+	intRangeField(
+		"ExifJpegQuality",
+		"the `quality` used when JPEG images have to be re-encoded",
+		func(config *Config) *int { return &config.ExifJpegQuality },
+		1,
+		100,
+	),
+	boolField(
+		"ExifKeepThumbnail",
+		"whether to keep embedded thumbnails",
+		func(config *Config) *bool { return &config.ExifKeepThumbnail },
+	),
+	boolField(
+		"ExifStripIccProfile",
+		"whether to also remove ICC color profiles",
+		func(config *Config) *bool { return &config.ExifStripIccProfile },
+	),
+	boolField(
+		"ExifStripOnError",
+		"whether to remove all metadata if EXIF scrubbing fails",
+		func(config *Config) *bool { return &config.ExifStripOnError },
+	),
+	boolField(
+		"ExifAbortOnError",
+		"whether to abort uploads whose metadata could not be removed",
+		func(config *Config) *bool { return &config.ExifAbortOnError },
+	),
+	{
+		key:   "ApiKey",
+		usage: "an API `key` as \"<name> <secret> <policy>\", may be given multiple times",
+		path:  "api_keys",
+		set: func(config *Config, val string) error {
+			apiKey, err := parseApiKey(val)
+			if err != nil {
+				return err
+			}
+
+			return config.addApiKey(apiKey)
+		},
+		setTable: func(config *Config, table map[string]any) error {
+			apiKey, err := apiKeyFromTable(table)
+			if err != nil {
+				return err
+			}
+
+			return config.addApiKey(apiKey)
+		},
+		get: func(config *Config) []string {
+			vals := make([]string, 0, len(config.ApiKeys))
+			for _, apiKey := range config.ApiKeys {
+				vals = append(
+					vals,
+					fmt.Sprintf("%s %s %s", apiKey.Name, redactedSecret, apiKey.ScrubPolicy),
+				)
+			}
+
+			return vals
+		},
+		reset: func(config *Config) {
+			config.ApiKeys = []ApiKey{}
+		},
+	},
+	boolField(
+		"RequireApiKey",
+		"whether to reject uploads without an API key",
+		func(config *Config) *bool { return &config.RequireApiKey },
+	),
+}
+
+func intField(key string, usage string, target func(config *Config) *int) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		set: func(config *Config, val string) error {
+			return setInt(target(config), val)
+		},
+		get: func(config *Config) []string {
+			return []string{strconv.Itoa(*target(config))}
+		},
+	}
+}
+
+// Like intField, but rejects values outside of [lower, upper]
+func intRangeField(
+	key string,
+	usage string,
+	target func(config *Config) *int,
+	lower int,
+	upper int,
+) configField {
+	field := intField(key, fmt.Sprintf("%s (%d to %d)", usage, lower, upper), target)
+	field.set = func(config *Config, val string) error {
+		parsed, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+
+		if parsed < lower || parsed > upper {
+			return errors.Errorf("must be between %d and %d, got %d", lower, upper, parsed)
+		}
+
+		*target(config) = parsed
+		return nil
+	}
+
+	return field
+}
+
+func boolField(key string, usage string, target func(config *Config) *bool) configField {
+	return configField{
+		key:    key,
+		usage:  usage,
+		isBool: true,
+		set: func(config *Config, val string) error {
+			return setBool(target(config), val)
+		},
+		get: func(config *Config) []string {
+			return []string{strconv.FormatBool(*target(config))}
+		},
+	}
+}
+
+func durationField(
+	key string,
+	usage string,
+	target func(config *Config) *time.Duration,
+) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		set: func(config *Config, val string) error {
+			parsed, err := time.ParseDuration(val)
+			if err != nil {
+				return err
+			}
+
+			if parsed < 0 {
+				return errors.Errorf("must not be negative, got %s", val)
+			}
+
+			*target(config) = parsed
+			return nil
+		},
+		get: func(config *Config) []string {
+			return []string{target(config).String()}
+		},
+	}
+}
+
+func stringField(key string, usage string, target func(config *Config) *string) configField {
+	return configField{
+		key:   key,
+		usage: usage,
+		set: func(config *Config, val string) error {
+			*target(config) = val
+			return nil
+		},
+		get: func(config *Config) []string {
+			return []string{*target(config)}
+		},
+	}
+}
+
+func tagIdsField(key string, usage string, target func(config *Config) *[]uint16) configField {
+	return configField{
+		key:    key,
+		usage:  usage,
+		isList: true,
+		set: func(config *Config, val string) error {
+			ids, err := parseTagIds(val)
+			if err != nil {
+				return err
+			}
+
+			*target(config) = ids
+			return nil
+		},
+		get: func(config *Config) []string {
+			ids := make([]string, 0, len(*target(config)))
+			for _, id := range *target(config) {
+				ids = append(ids, fmt.Sprintf("0x%04x", id))
+			}
+
+			return []string{strings.Join(ids, " ")}
+		},
+	}
 }
 
+func tagPathsField(key string, usage string, target func(config *Config) *[]string) configField {
+	return configField{
+		key:    key,
+		usage:  usage,
+		isList: true,
+		set: func(config *Config, val string) error {
+			paths, err := parseTagPaths(val)
+			if err != nil {
+				return err
+			}
+
+			*target(config) = paths
+			return nil
+		},
+		get: func(config *Config) []string {
+			// Presets have been expanded already
+			return []string{strings.Join(*target(config), " ")}
+		},
+	}
+}
+
+// Marks `field` as applying to the whole server
+func asGlobal(field configField) configField {
+	field.global = true
+	return field
+}
+
+// Marks `field` as an option of the listening server, which applies to the whole server and
+// cannot be changed without a restart
+func asServerOption(field configField) configField {
+	field.global = true
+	field.restart = true
+	return field
+}
+
+// Overrides the location of `field` in structured config files
+func withPath(path string, field configField) configField {
+	field.path = path
+	return field
+}
+
+func findConfigField(key string) *configField {
+	for i := range configFields {
+		if configFields[i].key == key {
+			return &configFields[i]
+		}
+	}
+
+	return nil
+}
+
+// Splits the key at the boundaries of its CamelCase words, e.g., "ExifAllowedIds" into "Exif",
+// "Allowed" and "Ids". Acronyms form a word of their own, e.g., "TLSCertFile" is split into "TLS",
+// "Cert" and "File".
+func (field *configField) words() []string {
+	words := []string{}
+
+	runes := []rune(field.key)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		startsWord := unicode.IsLower(runes[i-1]) ||
+			(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))
+		if unicode.IsUpper(runes[i]) && startsWord {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	return append(words, string(runes[start:]))
+}
+
+// Returns the name of the environment variable overriding `key`, e.g., "JAF_LINK_PREFIX" for
+// "LinkPrefix"
+func (field *configField) envName() string {
+	return envPrefix + strings.ToUpper(strings.Join(field.words(), "_"))
+}
+
+// Returns the location of `key` in structured config files. Keys starting with "Exif" belong to the
+// "exif" section, all others to the "server" section, e.g., "exif.allowed_ids" for
+// "ExifAllowedIds" and "server.link_prefix" for "LinkPrefix".
+func (field *configField) structuredPath() string {
+	if field.path != "" {
+		return field.path
+	}
+
+	words := field.words()
+	section := "server"
+	if len(words) > 1 && words[0] == "Exif" {
+		section = "exif"
+		words = words[1:]
+	}
+
+	return section + "." + strings.ToLower(strings.Join(words, "_"))
+}
+
+// Returns the name of the command-line flag overriding `key`, e.g., "link-prefix" for
+// "LinkPrefix"
+func (field *configField) flagName() string {
+	return strings.ToLower(strings.Join(field.words(), "-"))
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:              ListenModeTcp,
+		BindAddress:         "",
+		Port:                4711,
+		SocketPath:          "",
+		SocketMode:          0o660,
+		SocketOwner:         "",
+		TLSCertFile:         "",
+		TLSKeyFile:          "",
+		TLSRedirectPort:     0,
+		ReadHeaderTimeout:   10 * time.Second,
+		ReadTimeout:         30 * time.Second,
+		WriteTimeout:        30 * time.Second,
+		IdleTimeout:         2 * time.Minute,
+		MaxHeaderBytes:      0,
+		LinkPrefix:          "https://jaf.example.com/",
+		FileDir:             "/var/www/jaf/",
+		LinkLength:          5,
+		MaxUploadSize:       0,
+		ShutdownTimeout:     10 * time.Second,
+		ScrubExif:           true,
+		ExifMode:            exifscrubber.ModeAllowlist,
+		ExifAllowedIds:      []uint16{},
+		ExifAllowedPaths:    []string{},
+		ExifDeniedIds:       []uint16{},
+		ExifDeniedPaths:     []string{},
+		ExifCoarsenGps:      false,
+		ExifGpsDecimals:     2,
+		ExifBakeOrientation: false,
+		ExifJpegQuality:     exifscrubber.DefaultJpegQuality,
+		ExifKeepThumbnail:   false,
+		ExifStripIccProfile: false,
+		ExifStripOnError:    false,
+		ExifAbortOnError:    true,
+		ApiKeys:             []ApiKey{},
+		RequireApiKey:       false,
+		sources:             make(map[string]string),
+	}
+}
+
+// Reads the config from the file at `filePath`. Keys missing from the file keep their defaults.
+// Values that cannot be applied are reported by Validate.
 func ConfigFromFile(filePath string) (*Config, error) {
-	file, err := os.Open(filePath)
+	config := defaultConfig()
+
+	err := config.applyFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+
+	config.buildProfiles()
+	return config, nil
+}
+
+// Assembles the config from all sources in order of increasing precedence: defaults, the config
+// file at `filePath`, JAF_* environment variables and `flagValues` given on the command line.
+// Profiles are applied on top of the result. If `fileOptional` is set, a missing config file is not
+// an error. Values that cannot be applied are reported by Validate.
+func LoadConfig(filePath string, fileOptional bool, flagValues []flagValue) (*Config, error) {
+	config := defaultConfig()
+
+	err := config.applyFile(filePath)
+	if errors.Is(err, os.ErrNotExist) && fileOptional {
+		log.Printf("config file \"%s\" not found, using defaults and environment\n", filePath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	config.applyEnv(os.Environ())
+	config.applyFlags(flagValues)
+	config.buildProfiles()
+	return config, nil
+}
+
+// Applies the config file at `filePath`. Files with the extension of a structured format are
+// decoded as such, all others are read as "Key: value" lines.
+func (config *Config) applyFile(filePath string) error {
+	if decode := structuredDecoderFor(filePath); decode != nil {
+		return config.applyStructuredFile(filePath, decode)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
 
 	oldPrefix := log.Prefix()
@@ -37,23 +677,23 @@ func ConfigFromFile(filePath string) (*Config, error) {
 
 	log.SetPrefix("config.FromFile > ")
 
-	retval := &Config{
-		Port:             4711,
-		LinkPrefix:       "https://jaf.example.com/",
-		FileDir:          "/var/www/jaf/",
-		LinkLength:       5,
-		ScrubExif:        true,
-		ExifAllowedIds:   []uint16{},
-		ExifAllowedPaths: []string{},
-		ExifAbortOnError: true,
-	}
+	// Set while reading the section of a profile
+	var profile *profileSpec
 
 	scanner := bufio.NewScanner(file)
+	lineNumber := 0
 	for scanner.Scan() {
+		lineNumber++
 		line := strings.TrimSpace(scanner.Text())
 
-		if strings.HasPrefix(line, commentPrefix) {
-			// Skip comments
+		if line == "" || strings.HasPrefix(line, commentPrefix) {
+			// Skip blank lines and comments
+			continue
+		}
+
+		source := fmt.Sprintf("%s:%d", filePath, lineNumber)
+		if strings.HasPrefix(line, sectionPrefix) {
+			profile = config.addProfileSection(line, source)
 			continue
 		}
 
@@ -67,90 +707,282 @@ func ConfigFromFile(filePath string) (*Config, error) {
 		key = strings.TrimSpace(key)
 		val = strings.TrimSpace(val)
 
-		switch key {
-		case "Port":
-			parsed, err := strconv.Atoi(val)
-			if err != nil {
-				return nil, err
-			}
+		if profile != nil {
+			config.addProfileLine(profile, key, val, source)
+			continue
+		}
 
-			retval.Port = parsed
-		case "LinkPrefix":
-			retval.LinkPrefix = val
-		case "FileDir":
-			retval.FileDir = val
-		case "LinkLength":
-			parsed, err := strconv.Atoi(val)
-			if err != nil {
-				return nil, err
+		field := findConfigField(key)
+		if field == nil {
+			config.addProblem(source, key, errors.New("unknown config key"))
+			continue
+		}
+
+		config.setFrom(field, val, source)
+	}
+
+	return scanner.Err()
+}
+
+// Applies JAF_* variables from `environ` (as returned by os.Environ()) on top of the config.
+// Repeatable keys take a comma-separated list and replace all values from the config file.
+func (config *Config) applyEnv(environ []string) {
+	known := make(map[string]*configField, len(configFields))
+	for i := range configFields {
+		known[configFields[i].envName()] = &configFields[i]
+	}
+
+	for _, entry := range environ {
+		name, val, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+
+		field, found := known[name]
+		if !found {
+			log.Printf("unknown environment variable \"%s\", ignoring\n", name)
+			continue
+		}
+
+		source := "environment variable " + name
+		if field.reset == nil {
+			config.setFrom(field, strings.TrimSpace(val), source)
+			continue
+		}
+
+		field.reset(config)
+		for _, item := range strings.Split(val, envListSeparator) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
 			}
 
-			retval.LinkLength = parsed
-		case "ScrubExif":
-			parsed, err := strconv.ParseBool(val)
+			config.setFrom(field, item, source)
+		}
+	}
+}
+
+// A config value given as a command-line flag
+type flagValue struct {
+	field *configField
+	val   string
+}
+
+// Implements flag.Value for a config key. Values are only collected while parsing the flags and
+// applied on top of the other config sources later.
+type configFlag struct {
+	field  *configField
+	values *[]flagValue
+}
+
+func (f *configFlag) String() string {
+	if f.field == nil {
+		// Zero value used by the flag package to detect default values
+		return ""
+	}
+
+	return strings.Join(f.field.get(defaultConfig()), ", ")
+}
+
+func (f *configFlag) Set(val string) error {
+	// Report invalid values right away instead of after loading the other sources
+	err := f.field.set(defaultConfig(), val)
+	if err != nil {
+		return err
+	}
+
+	*f.values = append(*f.values, flagValue{field: f.field, val: val})
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool {
+	return f.field.isBool
+}
+
+// Defines a flag for every config key on `flags`. Parsed values are appended to `values`.
+func registerConfigFlags(flags *flag.FlagSet, values *[]flagValue) {
+	for i := range configFields {
+		field := &configFields[i]
+		flags.Var(&configFlag{field: field, values: values}, field.flagName(), field.usage)
+	}
+}
+
+// Applies values given as command-line flags on top of the config. Repeatable keys given as flags
+// replace all values from other sources.
+func (config *Config) applyFlags(values []flagValue) {
+	replaced := make(map[*configField]bool)
+	for _, value := range values {
+		if value.field.reset != nil && !replaced[value.field] {
+			value.field.reset(config)
+			replaced[value.field] = true
+		}
+
+		config.setFrom(value.field, value.val, "flag -"+value.field.flagName())
+	}
+}
+
+// Applies `val` to the key described by `field`, recording `source` as its origin. Invalid values
+// are recorded as problems and leave the config unchanged.
+func (config *Config) setFrom(field *configField, val string, source string) {
+	err := field.set(config, val)
+	if err != nil {
+		config.addProblem(source, field.key, err)
+		return
+	}
+
+	config.sources[field.key] = source
+}
+
+func (config *Config) addProblem(source string, key string, err error) {
+	config.problems = append(config.problems, ConfigProblem{
+		Source: source,
+		Key:    key,
+		Err:    err,
+	})
+}
+
+// Returns the address the server listens on, e.g., "127.0.0.1:4711" or "[::1]:4711"
+func (config *Config) listenAddress() string {
+	return net.JoinHostPort(trimBrackets(config.BindAddress), strconv.Itoa(config.Port))
+}
+
+// Removes the brackets around an IPv6 address like "[::1]"
+func trimBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+
+	return host
+}
+
+// Writes the config in the format of the config file. Profiles only list the values that differ
+// from the base config. API key secrets are redacted.
+func (config *Config) Write(w io.Writer) error {
+	err := config.writeFields(w, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range config.Profiles {
+		_, err := fmt.Fprintf(w, "\n[profile %s]\n", profile.Name)
+		if err != nil {
+			return err
+		}
+
+		if len(profile.Hosts) > 0 {
+			_, err := fmt.Fprintf(w, "%s: %s\n", hostsKey, strings.Join(profile.Hosts, " "))
 			if err != nil {
-				return nil, err
+				return err
 			}
+		}
 
-			retval.ScrubExif = parsed
-		case "ExifAllowedIds":
-			if val == "" {
-				// No IDs specified at all
-				break
-			}
+		err = profile.Config.writeFields(w, config)
+		if err != nil {
+			return err
+		}
+	}
 
-			stringIds := strings.Split(val, " ")
-
-			parsedIds := make([]uint16, 0, len(stringIds))
-			for _, stringId := range stringIds {
-				var parsed uint64
-				var err error
-
-				if strings.HasPrefix(stringId, "0x") {
-					// Parse as a hexadecimal number
-					hexStringId := strings.Replace(stringId, "0x", "", 1)
-					parsed, err = strconv.ParseUint(hexStringId, 16, 16)
-				} else {
-					// Parse as a decimal number
-					parsed, err = strconv.ParseUint(stringId, 10, 16)
-				}
-
-				if err != nil {
-					log.Printf(
-						"Could not parse ID from: \"%s\", ignoring. Error: %s\n",
-						stringId,
-						err,
-					)
-					continue
-				}
-
-				parsedIds = append(parsedIds, uint16(parsed))
-			}
+	return nil
+}
 
-			retval.ExifAllowedIds = parsedIds
-		case "ExifAllowedPaths":
-			if val == "" {
-				// No paths specified at all
-				break
-			}
+// Writes the values of all keys, skipping those equal to the values in `base` if given
+func (config *Config) writeFields(w io.Writer, base *Config) error {
+	for i := range configFields {
+		vals := configFields[i].get(config)
+		if base != nil && equalValues(vals, configFields[i].get(base)) {
+			continue
+		}
 
-			paths := strings.Split(val, " ")
-			retval.ExifAllowedPaths = paths
-		case "ExifAbortOnError":
-			parsed, err := strconv.ParseBool(val)
+		for _, val := range vals {
+			_, err := fmt.Fprintf(w, "%s: %s\n", configFields[i].key, val)
 			if err != nil {
-				return nil, err
+				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+func equalValues(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
 
-			retval.ExifAbortOnError = parsed
-		default:
-			return nil, errors.Errorf("unexpected config key: \"%s\"", key)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	return true
+}
+
+func setInt(target *int, val string) error {
+	parsed, err := strconv.Atoi(val)
+	if err != nil {
+		return err
+	}
+
+	*target = parsed
+	return nil
+}
+
+func setBool(target *bool, val string) error {
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		return err
+	}
+
+	*target = parsed
+	return nil
+}
+
+// Parses a space-separated list of decimal or hexadecimal (prefixed with "0x") EXIF tag IDs
+func parseTagIds(val string) ([]uint16, error) {
+	if val == "" {
+		// No IDs specified at all
+		return []uint16{}, nil
+	}
+
+	stringIds := strings.Fields(val)
+
+	parsedIds := make([]uint16, 0, len(stringIds))
+	for _, stringId := range stringIds {
+		var parsed uint64
+		var err error
+
+		if strings.HasPrefix(stringId, "0x") {
+			// Parse as a hexadecimal number
+			hexStringId := strings.Replace(stringId, "0x", "", 1)
+			parsed, err = strconv.ParseUint(hexStringId, 16, 16)
+		} else {
+			// Parse as a decimal number
+			parsed, err = strconv.ParseUint(stringId, 10, 16)
+		}
+
+		if err != nil {
+			return nil, errors.Errorf("invalid EXIF tag ID \"%s\"", stringId)
+		}
+
+		parsedIds = append(parsedIds, uint16(parsed))
+	}
+
+	return parsedIds, nil
+}
+
+// Parses a space-separated list of EXIF tag paths, path patterns and preset names. Presets are
+// expanded to the paths they stand for.
+func parseTagPaths(val string) ([]string, error) {
+	if val == "" {
+		// No paths specified at all
+		return []string{}, nil
+	}
+
+	paths, err := exifscrubber.ExpandPresets(strings.Fields(val))
+	if err != nil {
 		return nil, err
 	}
 
-	return retval, nil
+	return paths, exifscrubber.ValidatePaths(paths)
 }
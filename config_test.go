@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
 )
 
 func assertEqual[S comparable](have S, want S, t *testing.T) {
@@ -35,7 +43,265 @@ func TestConfigFromFile(t *testing.T) {
 	assertEqual(config.FileDir, "/var/www/jaf/", t)
 	assertEqual(config.LinkLength, 5, t)
 	assertEqual(config.ScrubExif, true, t)
+	assertEqual(config.ExifMode, exifscrubber.ModeAllowlist, t)
 	assertEqualSlice(config.ExifAllowedIds, []uint16{0x0112, 274}, t)
 	assertEqualSlice(config.ExifAllowedPaths, []string{"IFD/Orientation"}, t)
+	assertEqualSlice(config.ExifDeniedIds, []uint16{0xa431}, t)
+	assertEqualSlice(
+		config.ExifDeniedPaths,
+		[]string{
+			"IFD/Artist",
+			"IFD/Copyright",
+			"IFD/XPAuthor",
+			"IFD/Exif/CameraOwnerName",
+			"IFD/Exif/UserComment",
+		},
+		t,
+	)
+	assertEqual(config.ExifCoarsenGps, false, t)
+	assertEqual(config.ExifGpsDecimals, 2, t)
+	assertEqual(config.ExifBakeOrientation, false, t)
+	assertEqual(config.ExifJpegQuality, 90, t)
+	assertEqual(config.ExifKeepThumbnail, false, t)
+	assertEqual(config.ExifStripIccProfile, false, t)
+	assertEqual(config.ExifStripOnError, false, t)
 	assertEqual(config.ExifAbortOnError, true, t)
+	assertEqual(len(config.ApiKeys), 0, t)
+	assertEqual(config.Listen, ListenModeTcp, t)
+	assertEqual(config.BindAddress, "", t)
+	assertEqual(config.SocketPath, "/run/jaf/jaf.sock", t)
+	assertEqual(config.SocketMode, os.FileMode(0o660), t)
+	assertEqual(config.SocketOwner, "", t)
+	assertEqual(config.TLSCertFile, "", t)
+	assertEqual(config.TLSKeyFile, "", t)
+	assertEqual(config.TLSRedirectPort, 0, t)
+	assertEqual(config.ReadHeaderTimeout, 10*time.Second, t)
+	assertEqual(config.ReadTimeout, 30*time.Second, t)
+	assertEqual(config.WriteTimeout, 30*time.Second, t)
+	assertEqual(config.IdleTimeout, 2*time.Minute, t)
+	assertEqual(config.MaxHeaderBytes, 0, t)
+	assertEqual(config.MaxUploadSize, 0, t)
+	assertEqual(config.ShutdownTimeout, 10*time.Second, t)
+	assertEqual(config.RequireApiKey, false, t)
+	assertEqual(len(config.Profiles), 0, t)
+}
+
+func TestConfigFromFileWarnings(t *testing.T) {
+	b := new(bytes.Buffer)
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	// The shipped example uses blank lines to separate sections
+	if _, err := ConfigFromFile("example.conf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Len() > 0 {
+		t.Errorf("example.conf logged warnings: %q", b.String())
+	}
+}
+
+func TestConfigNames(t *testing.T) {
+	type tType struct {
+		key      string
+		wantEnv  string
+		wantFlag string
+	}
+
+	tests := []tType{
+		{key: "Port", wantEnv: "JAF_PORT", wantFlag: "port"},
+		{key: "LinkPrefix", wantEnv: "JAF_LINK_PREFIX", wantFlag: "link-prefix"},
+		{key: "ExifAllowedIds", wantEnv: "JAF_EXIF_ALLOWED_IDS", wantFlag: "exif-allowed-ids"},
+		{key: "ApiKey", wantEnv: "JAF_API_KEY", wantFlag: "api-key"},
+		{key: "TLSCertFile", wantEnv: "JAF_TLS_CERT_FILE", wantFlag: "tls-cert-file"},
+	}
+
+	for _, test := range tests {
+		field := findConfigField(test.key)
+		assertEqual(field.envName(), test.wantEnv, t)
+		assertEqual(field.flagName(), test.wantFlag, t)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.ApiKeys = []ApiKey{{Name: "file", Secret: "file-secret", ScrubPolicy: ScrubPolicyAlways}}
+
+	config.applyEnv([]string{
+		"HOME=/root",
+		"JAF_PORT=8080",
+		"JAF_LINK_PREFIX=https://files.example.com/",
+		"JAF_EXIF_DENIED_PATHS=gps",
+		"JAF_API_KEY=one s3cr3t optin, two t0ken always",
+		"JAF_UNKNOWN=ignored",
+	})
+	if err := config.validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(config.Port, 8080, t)
+	assertEqual(config.LinkPrefix, "https://files.example.com/", t)
+	assertEqualSlice(config.ExifDeniedPaths, []string{"IFD/GPSInfo/**"}, t)
+	// Values not given in the environment are kept
+	assertEqual(config.FileDir, "/var/www/jaf/", t)
+	// Repeatable keys are replaced, not extended
+	assertEqualSlice(config.ApiKeys, []ApiKey{
+		{Name: "one", Secret: "s3cr3t", ScrubPolicy: ScrubPolicyOptIn},
+		{Name: "two", Secret: "t0ken", ScrubPolicy: ScrubPolicyAlways},
+	}, t)
+
+	config.applyEnv([]string{"JAF_LINK_LENGTH=five"})
+	err = config.validate(false)
+	if err == nil || !strings.Contains(err.Error(), "JAF_LINK_LENGTH") {
+		t.Errorf("have error %v, want error naming the variable", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("JAF_PORT", "8080")
+
+	// Environment takes precedence over the file
+	config, err := LoadConfig("example.conf", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Port, 8080, t)
+	assertEqual(config.LinkLength, 5, t)
+
+	// A missing config file is only fine if none was asked for explicitly
+	config, err = LoadConfig("does-not-exist.conf", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Port, 8080, t)
+	assertEqual(config.FileDir, "/var/www/jaf/", t)
+
+	_, err = LoadConfig("does-not-exist.conf", false, nil)
+	if err == nil {
+		t.Error("missing config file accepted")
+	}
+}
+
+func TestConfigFromFlags(t *testing.T) {
+	t.Setenv("JAF_PORT", "8080")
+	t.Setenv("JAF_LINK_LENGTH", "7")
+
+	params := parseParams(commandServe, []string{
+		"-configFile", "example.conf",
+		"-port", "9090",
+		"-scrub-exif=false",
+		"-exif-coarsen-gps",
+		"-api-key", "one s3cr3t optin",
+		"-api-key", "two t0ken always",
+	})
+
+	config, err := params.loadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags take precedence over the environment, which takes precedence over the file
+	assertEqual(config.Port, 9090, t)
+	assertEqual(config.LinkLength, 7, t)
+	assertEqual(config.FileDir, "/var/www/jaf/", t)
+	assertEqual(config.ScrubExif, false, t)
+	assertEqual(config.ExifCoarsenGps, true, t)
+	assertEqualSlice(config.ApiKeys, []ApiKey{
+		{Name: "one", Secret: "s3cr3t", ScrubPolicy: ScrubPolicyOptIn},
+		{Name: "two", Secret: "t0ken", ScrubPolicy: ScrubPolicyAlways},
+	}, t)
+}
+
+func TestConfigWrite(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "written.conf")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Write(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The written config must read back to the same values
+	reread, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range configFields {
+		assertEqualSlice(field.get(reread), field.get(config), t)
+	}
+
+	// Secrets must not be printed
+	var b strings.Builder
+	config.ApiKeys = []ApiKey{{Name: "one", Secret: "s3cr3t", ScrubPolicy: ScrubPolicyOptIn}}
+	if err := config.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(b.String(), "s3cr3t") {
+		t.Error("API key secret was printed")
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	type tType struct {
+		bindAddress string
+		want        string
+		valid       bool
+	}
+
+	tests := []tType{
+		{bindAddress: "", want: ":4711", valid: true},
+		{bindAddress: "127.0.0.1", want: "127.0.0.1:4711", valid: true},
+		{bindAddress: "localhost", want: "localhost:4711", valid: true},
+		{bindAddress: "::1", want: "[::1]:4711", valid: true},
+		{bindAddress: "[::]", want: "[::]:4711", valid: true},
+		{bindAddress: "127.0.0.1:8080", valid: false},
+		{bindAddress: "[::1]:8080", valid: false},
+	}
+
+	for _, test := range tests {
+		config := defaultConfig()
+		config.BindAddress = test.bindAddress
+
+		err := config.validate(false)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("bind address \"%s\" accepted", test.bindAddress)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("bind address \"%s\" rejected: %s", test.bindAddress, err)
+		}
+		assertEqual(config.listenAddress(), test.want, t)
+	}
+}
+
+func TestParseTagPaths(t *testing.T) {
+	paths, err := parseTagPaths("IFD/Orientation  IFD/Make\tIFD/Model ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualSlice(paths, []string{"IFD/Orientation", "IFD/Make", "IFD/Model"}, t)
+
+	paths, err = parseTagPaths("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(paths), 0, t)
 }
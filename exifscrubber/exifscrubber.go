@@ -16,127 +16,339 @@ import (
 
 var ErrUnknownFileType = errors.New("can't scrub EXIF for this file type")
 
+var ErrMalformedExif = errors.New("malformed EXIF")
+
+// ChildIfdError is returned if a tag points to a child IFD that was not parsed, e.g., because its
+// offset is invalid
+type ChildIfdError struct {
+	// Fully-qualified path of the IFD containing the tag
+	IfdPath     string
+	TagId       uint16
+	TagPosition int
+}
+
+func (err *ChildIfdError) Error() string {
+	return fmt.Sprintf(
+		"could not find child IFD for tag 0x%04x at position %d in %s",
+		err.TagId,
+		err.TagPosition,
+		err.IfdPath,
+	)
+}
+
+// Mode determines how the configured tag IDs and paths are interpreted
+type Mode string
+
+const (
+	// Only the listed tags are kept, everything else is removed
+	ModeAllowlist Mode = "allowlist"
+	// Only the listed tags are removed, everything else is kept
+	ModeDenylist Mode = "denylist"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeAllowlist, ModeDenylist:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown EXIF mode: \"%s\"", s)
+	}
+}
+
 type ExifScrubber struct {
-	includedTagIds   []uint16
-	includedTagPaths []string
+	mode        Mode
+	tagIds      []uint16
+	pathMatcher pathMatcher
+	coarsenGps  bool
+	gpsDecimals int
+	// Whether to apply the orientation to the pixel data and remove all EXIF
+	bakeOrientation bool
+	jpegQuality     int
+	// Images with more pixels are rejected instead of baking their orientation
+	maxBakedPixels int
+	// Whether to keep the thumbnail IFD (IFD1) and the embedded thumbnail image
+	keepThumbnail bool
+	// Whether to remove ICC color profiles as well
+	stripIccProfile bool
+	logger          Logger
+}
+
+// Creates a scrubber that removes all tags except the ones specified. Tag paths may contain glob
+// patterns (see ValidatePaths).
+func NewExifScrubber(includedTagIds []uint16, includedTagPaths []string, options Options) ExifScrubber {
+	return ExifScrubber{
+		mode:        ModeAllowlist,
+		tagIds:      includedTagIds,
+		pathMatcher: newPathMatcher(includedTagPaths),
+		logger:      loggerOrDefault(options.Logger),
+	}
 }
 
-func NewExifScrubber(includedTagIds []uint16, includedTagPaths []string) ExifScrubber {
+// Creates a scrubber that keeps all tags except the ones specified. Tag paths may contain glob
+// patterns (see ValidatePaths).
+func NewDenyingExifScrubber(
+	excludedTagIds []uint16,
+	excludedTagPaths []string,
+	options Options,
+) ExifScrubber {
 	return ExifScrubber{
-		includedTagIds:   includedTagIds,
-		includedTagPaths: includedTagPaths,
+		mode:        ModeDenylist,
+		tagIds:      excludedTagIds,
+		pathMatcher: newPathMatcher(excludedTagPaths),
+		logger:      loggerOrDefault(options.Logger),
 	}
 }
 
-func (scrubber *ExifScrubber) ScrubExif(fileData []byte) ([]byte, error) {
+// Round the GPS coordinates kept by the allow/deny rules to `decimals` decimal places of a degree
+// (clamped to [0, MaxGpsDecimals]). Tags that reveal a more precise location, such as altitude,
+// speed and direction, are always removed in this case.
+func (scrubber *ExifScrubber) EnableGpsCoarsening(decimals int) {
+	if decimals < 0 {
+		decimals = 0
+	} else if decimals > MaxGpsDecimals {
+		decimals = MaxGpsDecimals
+	}
+
+	scrubber.coarsenGps = true
+	scrubber.gpsDecimals = decimals
+}
+
+// Keep the thumbnail section (IFD1) and the embedded thumbnail image. Tags in IFD1 are filtered
+// like all other tags. Per default, the thumbnail section is removed entirely since thumbnails may
+// show the original image, e.g., before it was cropped.
+func (scrubber *ExifScrubber) EnableThumbnailKeeping() {
+	scrubber.keepThumbnail = true
+}
+
+// Removes all EXIF tags that are not explicitly allowed from JPEG and PNG files. Returns the
+// scrubbed file data along with a report on which tags were removed and which were kept. ICC color
+// profiles are preserved unless configured otherwise.
+func (scrubber *ExifScrubber) ScrubExif(fileData []byte) ([]byte, *ScrubReport, error) {
+	scrubbedData, report, err := scrubber.scrubExif(fileData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scrubbedData, err = scrubber.applyIccPolicy(scrubbedData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Defense in depth: make sure we don't hand out anything we were supposed to remove, even if
+	// the filtering above is buggy. The input was a JPEG or PNG file, so the output must be one as
+	// well; otherwise verification would pass without checking anything.
+	err = scrubber.verify(scrubbedData)
+	if errors.Is(err, ErrUnknownFileType) {
+		return nil, nil, fmt.Errorf("%w: scrubbed file is no longer a JPEG or PNG file",
+			ErrMalformedImage)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return scrubbedData, report, nil
+}
+
+func (scrubber *ExifScrubber) scrubExif(fileData []byte) ([]byte, *ScrubReport, error) {
+	report := newScrubReport()
+
 	// Try scrubbing using JPEG package
 	jpegParser := jis.NewJpegMediaParser()
 	if jpegParser.LooksLikeFormat(fileData) {
 		intfc, err := jpegParser.ParseBytes(fileData)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		segmentList := intfc.(*jis.SegmentList)
-		rootIfd, _, err := segmentList.Exif()
+		rootIfd, rawExif, err := segmentList.Exif()
 		if err != nil {
 			if exiflog.Is(err, exif.ErrNoExif) {
 				// Incoming data contained no EXIF in the first place so we can return the original
-				return fileData, nil
+				return fileData, report, nil
 			}
 
-			return nil, err
+			return nil, nil, err
+		}
+
+		if scrubber.bakeOrientation {
+			return scrubber.bakeOrientationAndStrip(
+				fileData,
+				rootIfd,
+				rawExif,
+				report,
+				func() ([]byte, error) {
+					_, err := segmentList.DropExif()
+					if err != nil {
+						return nil, err
+					}
+
+					b := new(bytes.Buffer)
+					err = segmentList.Write(b)
+					return b.Bytes(), err
+				},
+			)
 		}
 
-		filteredIb, err := scrubber.filteringIfdBuilder(rootIfd)
+		filteredIb, err := scrubber.filteringIfdBuilder(rootIfd, rawExif, report)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		segmentList.SetExif(filteredIb)
 
 		b := new(bytes.Buffer)
 		err = segmentList.Write(b)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		return b.Bytes(), nil
+		return b.Bytes(), report, nil
 	}
 
 	// Try scrubbing using PNG package
-	pngParser := pis.NewPngMediaParser()
-	if pngParser.LooksLikeFormat(fileData) {
+	if looksLikePng(fileData) {
+		pngParser := pis.NewPngMediaParser()
 		intfc, err := pngParser.ParseBytes(fileData)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		chunks := intfc.(*pis.ChunkSlice)
-		rootIfd, _, err := chunks.Exif()
+		rootIfd, rawExif, err := chunks.Exif()
 		if err != nil {
 			if exiflog.Is(err, exif.ErrNoExif) {
 				// Incoming data contained no EXIF in the first place so we can return the original
-				return fileData, nil
+				return fileData, report, nil
 			}
 
-			return nil, err
+			return nil, nil, err
 		}
 
-		filteredIb, err := scrubber.filteringIfdBuilder(rootIfd)
+		if scrubber.bakeOrientation {
+			return scrubber.bakeOrientationAndStrip(
+				fileData,
+				rootIfd,
+				rawExif,
+				report,
+				func() ([]byte, error) {
+					keptChunks := make([]*pis.Chunk, 0, len(chunks.Chunks()))
+					for _, chunk := range chunks.Chunks() {
+						if chunk.Type != pis.EXifChunkType {
+							keptChunks = append(keptChunks, chunk)
+						}
+					}
+
+					b := new(bytes.Buffer)
+					err := pis.NewChunkSlice(keptChunks).WriteTo(b)
+					return b.Bytes(), err
+				},
+			)
+		}
+
+		filteredIb, err := scrubber.filteringIfdBuilder(rootIfd, rawExif, report)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		chunks.SetExif(filteredIb)
 
 		b := new(bytes.Buffer)
 		err = chunks.WriteTo(b)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		return b.Bytes(), nil
+		return b.Bytes(), report, nil
+	}
+
+	// The JPEG parser only accepts files ending in an EOI marker, but truncated files and files with
+	// trailing data are still displayed and may carry EXIF, so they must not pass as unknown
+	if bytes.HasPrefix(fileData, []byte{jpegMarkerPrefix, jpegMarkerSoi}) {
+		return nil, nil, fmt.Errorf("%w: JPEG file does not end with an EOI marker",
+			ErrMalformedImage)
 	}
 
 	// Don't know how to handle other file formats, so we let the caller decide how to continue
-	return nil, ErrUnknownFileType
+	return nil, nil, ErrUnknownFileType
 }
 
-// Check whether the tag represented by `tag` is included in the path or tag ID list
+// Check whether the tag represented by `tag` survives scrubbing in the configured mode
 func (scrubber *ExifScrubber) isTagAllowed(tag *exif.IfdTagEntry) bool {
-	// Check via IDs first (faster than string comparisons)
-	for _, includedId := range scrubber.includedTagIds {
-		if includedId == tag.TagId() {
-			return true
-		}
+	listed := scrubber.isTagListed(tag.IfdIdentity(), tag.TagId(), tag.TagName())
+
+	if scrubber.mode == ModeDenylist {
+		return !listed
 	}
 
-	// If no IDs matched, also check IFD tag paths for inclusion
-	tagPath := fmt.Sprintf("%s/%s", tag.IfdPath(), tag.TagName())
+	return listed
+}
 
-	for _, includedPath := range scrubber.includedTagPaths {
-		if includedPath == tagPath {
+// Check whether a tag the parser skipped survives scrubbing. Only the denylist mode keeps such
+// tags, as long as they are not denied by ID or path and their value is not an offset, see
+// unparsedTag.mayBeOffset(). They are not kept in the GPS IFD if GPS coordinates are coarsened,
+// since they might hold coordinates in a format coarsening does not understand.
+func (scrubber *ExifScrubber) isUnparsedTagAllowed(
+	ifdIdentity *exifcommon.IfdIdentity,
+	tag unparsedTag,
+) bool {
+	if scrubber.mode != ModeDenylist || tag.tagId == makerNoteTagId || !tag.isCopyable() ||
+		tag.mayBeOffset() {
+		return false
+	}
+
+	if scrubber.coarsenGps && isGpsIfd(ifdIdentity) {
+		return false
+	}
+
+	return !scrubber.isTagListed(ifdIdentity, tag.tagId, tag.tagName)
+}
+
+// Check whether the tag is included in the path or tag ID list. Tags unknown to the standard have
+// an empty name and can only be matched by ID or by wildcards.
+func (scrubber *ExifScrubber) isTagListed(
+	ifdIdentity *exifcommon.IfdIdentity,
+	tagId uint16,
+	tagName string,
+) bool {
+	// Check via IDs first (faster than string comparisons)
+	for _, listedId := range scrubber.tagIds {
+		if listedId == tagId {
 			return true
 		}
 	}
 
-	return false
+	// If no IDs matched, also check IFD tag paths for inclusion. Tags outside of the first IFD
+	// chain entry are addressed by their fully-qualified path, e.g., "IFD1/XResolution" for tags
+	// in the thumbnail section.
+	tagPath := fmt.Sprintf("%s/%s", ifdIdentity.String(), tagName)
+	return scrubber.pathMatcher.matches(tagPath)
 }
 
 // This method follows the implementation of exif.NewIfdBuilderFromExistingChain()
-func (scrubber *ExifScrubber) filteringIfdBuilder(rootIfd *exif.Ifd) (
+func (scrubber *ExifScrubber) filteringIfdBuilder(
+	rootIfd *exif.Ifd,
+	rawExif []byte,
+	report *ScrubReport,
+) (
 	firstIb *exif.IfdBuilder,
 	err error,
 ) {
 	var lastIb *exif.IfdBuilder
 	i := 0
 	for thisExistingIfd := rootIfd; thisExistingIfd != nil; thisExistingIfd = thisExistingIfd.NextIfd() {
-		// This only works when no non-standard mappings are used
+		if !scrubber.keepThumbnail && isThumbnailIfd(thisExistingIfd.IfdIdentity()) {
+			// Drop this and all following IFDs of the chain along with their thumbnails
+			report.addRemovedChain(thisExistingIfd)
+			break
+		}
+
+		// The parser only descends into standard IFDs and only yields standard tags, so the
+		// standard mapping and tag index cover everything we will add. Non-standard tags have been
+		// skipped while parsing; filteredAddTagsFromExisting() reads them from the raw EXIF data
+		// and adds them with their raw values if they are kept.
 		ifdMapping, err := exifcommon.NewIfdMappingWithStandard()
 		if err != nil {
 			return nil, err
 		}
 
-		// This only works when no non-standard tags are used
 		tagIndex := exif.NewTagIndex()
 		err = exif.LoadStandardTags(tagIndex)
 		if err != nil {
@@ -156,7 +368,19 @@ func (scrubber *ExifScrubber) filteringIfdBuilder(rootIfd *exif.Ifd) (
 			lastIb.SetNextIb(newIb)
 		}
 
-		err = scrubber.filteredAddTagsFromExisting(newIb, thisExistingIfd)
+		if scrubber.keepThumbnail {
+			thumbnailData, err := thisExistingIfd.Thumbnail()
+			if err == nil {
+				err = newIb.SetThumbnail(thumbnailData)
+				if err != nil {
+					return nil, err
+				}
+			} else if !exiflog.Is(err, exif.ErrNoThumbnail) {
+				return nil, err
+			}
+		}
+
+		err = scrubber.filteredAddTagsFromExisting(newIb, thisExistingIfd, rawExif, tagIndex, report)
 		if err != nil {
 			return nil, err
 		}
@@ -168,12 +392,56 @@ func (scrubber *ExifScrubber) filteringIfdBuilder(rootIfd *exif.Ifd) (
 	return firstIb, nil
 }
 
+// Figures out which of the `children` of the IFD at `ifdPath` the tag `tagId` at `tagPosition`
+// points to. Fails with a ChildIfdError if there is none, e.g., because it could not be parsed.
+func (scrubber *ExifScrubber) childIfdOf(
+	ifdPath string,
+	children []*exif.Ifd,
+	tagId uint16,
+	tagPosition int,
+) (*exif.Ifd, error) {
+	for _, childIfd := range children {
+		if childIfd.ParentTagIndex() != tagPosition {
+			continue
+		}
+
+		if childIfd.IfdIdentity().TagId() != 0xffff && childIfd.IfdIdentity().TagId() != tagId {
+			scrubber.logger.Warn(
+				"child IFD does not match the tag pointing to it",
+				"ifdPath", ifdPath,
+				"tagId", fmt.Sprintf("0x%04x", tagId),
+				"tagPosition", tagPosition,
+				"childIfdPath", childIfd.IfdIdentity().String(),
+			)
+		}
+
+		return childIfd, nil
+	}
+
+	// Without the child IFD we can neither filter nor report its tags
+	return nil, &ChildIfdError{IfdPath: ifdPath, TagId: tagId, TagPosition: tagPosition}
+}
+
 // This method follows the implementation of exif.IfdBuilder.AddTagsFromExisting()
 func (scrubber *ExifScrubber) filteredAddTagsFromExisting(
 	ib *exif.IfdBuilder,
 	ifd *exif.Ifd,
+	rawExif []byte,
+	tagIndex *exif.TagIndex,
+	report *ScrubReport,
 ) (err error) {
+	unparsedTags := scrubber.keptUnparsedTags(ifd, rawExif, tagIndex, report)
+
 	for i, ite := range ifd.Entries() {
+		// Keep the tags in ascending order as required by the standard
+		for len(unparsedTags) > 0 && unparsedTags[0].tagId < ite.TagId() {
+			err := addUnparsedTag(ib, ifd, unparsedTags[0], rawExif)
+			if err != nil {
+				return err
+			}
+			unparsedTags = unparsedTags[1:]
+		}
+
 		if ite.IsThumbnailOffset() == true || ite.IsThumbnailSize() {
 			// These will be added on-the-fly when we encode.
 			continue
@@ -184,73 +452,59 @@ func (scrubber *ExifScrubber) filteredAddTagsFromExisting(
 			// If we want to add an IFD tag, we'll have to build it first and
 			// *then* add it via a different method.
 
-			// Figure out which of the child-IFDs that are associated with
-			// this IFD represents this specific child IFD.
-
-			var childIfd *exif.Ifd
-			for _, thisChildIfd := range ifd.Children() {
-				if thisChildIfd.ParentTagIndex() != i {
-					continue
-				} else if thisChildIfd.IfdIdentity().TagId() != 0xffff &&
-					thisChildIfd.IfdIdentity().TagId() != ite.TagId() {
-					fmt.Printf(
-						"child-IFD tag is not correct: TAG-POSITION=(%d) ITE=%s CHILD-IFD=%s\n",
-						thisChildIfd.ParentTagIndex(),
-						ite,
-						thisChildIfd,
-					)
-				}
-
-				childIfd = thisChildIfd
-				break
-			}
-
-			if childIfd == nil {
-				childTagIds := make([]string, len(ifd.Children()))
-				for j, childIfd := range ifd.Children() {
-					childTagIds[j] = fmt.Sprintf(
-						"0x%04x (parent tag-position %d)",
-						childIfd.IfdIdentity().TagId(),
-						childIfd.ParentTagIndex(),
-					)
-				}
-
-				fmt.Printf(
-					"could not find child IFD for child ITE: IFD-PATH=[%s] TAG-ID=(0x%04x) "+
-						"CURRENT-TAG-POSITION=(%d) CHILDREN=%v\n",
-					ite.IfdPath(),
-					ite.TagId(),
-					i,
-					childTagIds,
-				)
+			childIfd, err := scrubber.childIfdOf(
+				ifd.IfdIdentity().String(),
+				ifd.Children(),
+				ite.TagId(),
+				i,
+			)
+			if err != nil {
+				return err
 			}
 
-			childIb, err := scrubber.filteringIfdBuilder(childIfd)
+			childIb, err := scrubber.filteringIfdBuilder(childIfd, rawExif, report)
 			if err != nil {
 				return err
 			}
 
+			if childIb == nil || len(childIb.Tags()) == 0 {
+				// Don't keep empty IFDs around, their mere presence may reveal information (e.g.,
+				// that a picture has been geotagged)
+				continue
+			}
+
 			bt = ib.NewBuilderTagFromBuilder(childIb)
 		} else {
 			// Non-IFD tag.
+			if ite.TagId() == makerNoteTagId {
+				// Maker notes are opaque, vendor-specific blobs that commonly contain serial
+				// numbers or owner names. They often reference data via offsets which break when
+				// the EXIF block is rewritten anyway, so they are always removed.
+				report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), true)
+				continue
+			}
+
+			if scrubber.coarsenGps && isGpsIfd(ifd.IfdIdentity()) {
+				handled, err := scrubber.coarsenGpsTag(ib, ifd, ite, rawExif, report)
+				if err != nil {
+					return err
+				}
+
+				if handled {
+					continue
+				}
+			}
+
 			isAllowed := scrubber.isTagAllowed(ite)
+			report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), !isAllowed)
 			if !isAllowed {
 				continue
 			}
 
-			rawBytes, err := ite.GetRawBytes()
+			bt, err = builderTagFromExisting(ifd, ite, rawExif)
 			if err != nil {
 				return err
 			}
-
-			value := exif.NewIfdBuilderTagValueFromBytes(rawBytes)
-			bt = exif.NewBuilderTag(
-				ifd.IfdIdentity().UnindexedString(),
-				ite.TagId(),
-				ite.TagType(),
-				value,
-				ifd.ByteOrder(),
-			)
 		}
 
 		if bt.Value().IsBytes() {
@@ -266,5 +520,82 @@ func (scrubber *ExifScrubber) filteredAddTagsFromExisting(
 		}
 	}
 
+	for _, tag := range unparsedTags {
+		err := addUnparsedTag(ib, ifd, tag, rawExif)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// Creates a builder tag holding the unmodified value of the existing non-IFD tag `ite`
+func builderTagFromExisting(
+	ifd *exif.Ifd,
+	ite *exif.IfdTagEntry,
+	rawExif []byte,
+) (*exif.BuilderTag, error) {
+	err := checkValueSize(ite, rawExif)
+	if err != nil {
+		return nil, err
+	}
+
+	rawBytes, err := ite.GetRawBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	value := exif.NewIfdBuilderTagValueFromBytes(rawBytes)
+	bt := exif.NewBuilderTag(
+		ifd.IfdIdentity().UnindexedString(),
+		ite.TagId(),
+		ite.TagType(),
+		value,
+		ifd.ByteOrder(),
+	)
+
+	return bt, nil
+}
+
+// Returns the value of `ite` after checking that it fits into `rawExif`
+func tagValue(ite *exif.IfdTagEntry, rawExif []byte) (interface{}, error) {
+	err := checkValueSize(ite, rawExif)
+	if err != nil {
+		return nil, err
+	}
+
+	return ite.Value()
+}
+
+// The EXIF library allocates as much memory as the unit count of a tag asks for before reading
+// its value. A manipulated count could make it allocate gigabytes, so values that cannot possibly
+// fit into the EXIF data are rejected beforehand.
+func checkValueSize(ite *exif.IfdTagEntry, rawExif []byte) error {
+	// Values of undefined type are read as bytes
+	unitSize := 1
+	if ite.TagType() != exifcommon.TypeUndefined {
+		unitSize = ite.TagType().Size()
+	}
+
+	size := uint64(ite.UnitCount()) * uint64(unitSize)
+	if size > uint64(len(rawExif)) {
+		return fmt.Errorf(
+			"%w: tag 0x%04x in %s claims %d bytes but the EXIF data only has %d",
+			ErrMalformedExif,
+			ite.TagId(),
+			ite.IfdIdentity(),
+			size,
+			len(rawExif),
+		)
+	}
+
+	return nil
+}
+
+// Whether the IFD follows the first IFD of the root chain, i.e., is IFD1 or later. These IFDs
+// describe the embedded thumbnail.
+func isThumbnailIfd(ifdIdentity *exifcommon.IfdIdentity) bool {
+	return ifdIdentity.Index() > 0 &&
+		ifdIdentity.UnindexedString() == exifcommon.IfdStandardIfdIdentity.UnindexedString()
+}
@@ -1,6 +1,9 @@
 package exifscrubber
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"io/ioutil"
 	"log"
 	"testing"
@@ -8,6 +11,7 @@ import (
 	"golang.org/x/exp/slices"
 
 	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
 	jis "github.com/dsoprea/go-jpeg-image-structure/v2"
 	pis "github.com/dsoprea/go-png-image-structure/v2"
 )
@@ -26,9 +30,9 @@ func TestJpgFromFile(t *testing.T) {
 		"IFD/GPSInfo/GPSDateStamp",
 	}
 
-	scrubber := NewExifScrubber(includeTagIds[:], includedPaths[:])
+	scrubber := NewExifScrubber(includeTagIds[:], includedPaths[:], Options{})
 
-	updatedBuf, err := scrubber.ScrubExif(buf)
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
 	if err != nil {
 		log.Println(err)
 	}
@@ -77,9 +81,9 @@ func TestPngFromFile(t *testing.T) {
 		"IFD/GPSInfo/GPSDateStamp",
 	}
 
-	scrubber := NewExifScrubber(includeTagIds[:], includedPaths[:])
+	scrubber := NewExifScrubber(includeTagIds[:], includedPaths[:], Options{})
 
-	updatedBuf, err := scrubber.ScrubExif(buf)
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
 	if err != nil {
 		log.Println(err)
 	}
@@ -114,3 +118,403 @@ func TestPngFromFile(t *testing.T) {
 
 	rootIfd.EnumerateTagsRecursively(visitor)
 }
+
+func TestScrubReport(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	includedPaths := []string{
+		"IFD/Orientation",
+		"IFD/GPSInfo/GPSTimeStamp",
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, includedPaths[:], Options{})
+
+	_, report, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.KeptCount() != len(includedPaths) {
+		t.Errorf("have %d kept tags, want %d", report.KeptCount(), len(includedPaths))
+	}
+
+	if report.RemovedCount() == 0 {
+		t.Errorf("no tags reported as removed")
+	}
+
+	if !report.KeptGps() {
+		t.Errorf("GPSTimeStamp not reported as kept GPS tag")
+	}
+
+	if report.KeptIdentifying() {
+		t.Errorf("ImageUniqueID reported as kept")
+	}
+
+	for _, tag := range report.Tags {
+		path := tag.IfdPath + "/" + tag.TagName
+
+		if tag.Gps != (tag.IfdPath == "IFD/GPSInfo") {
+			t.Errorf("tag %s has wrong GPS flag: %t", path, tag.Gps)
+		}
+
+		if tag.Identifying != (path == "IFD/Exif/ImageUniqueID") {
+			t.Errorf("tag %s has wrong identifying flag: %t", path, tag.Identifying)
+		}
+
+		if tag.TagName == "GPSLatitude" && !tag.Removed {
+			t.Errorf("tag %s reported as kept", path)
+		}
+
+		if path == "IFD/Orientation" && tag.Removed {
+			t.Errorf("tag %s reported as removed", path)
+		}
+	}
+}
+
+func TestDenylistFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	excludedPaths, err := ExpandPresets([]string{"gps", "IFD/Model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrubber := NewDenyingExifScrubber([]uint16{0x9209}, excludedPaths, Options{}) // ID of "Flash" tag
+
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(updatedBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sl := intfc.(*jis.SegmentList)
+	rootIfd, _, err := sl.Exif()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keptMake := false
+	visitor := func(ifd *exif.Ifd, ite *exif.IfdTagEntry) error {
+		tagPath := ite.IfdPath() + "/" + ite.TagName()
+
+		if ite.TagId() == 0x9209 || ite.IfdPath() == "IFD/GPSInfo" || tagPath == "IFD/Model" {
+			t.Errorf("tag %s included in EXIF although it has been denied", tagPath)
+		}
+
+		if tagPath == "IFD/Make" {
+			keptMake = true
+		}
+
+		return nil
+	}
+
+	rootIfd.EnumerateTagsRecursively(visitor)
+
+	if !keptMake {
+		t.Errorf("tag IFD/Make removed although it hasn't been denied")
+	}
+}
+
+func TestExpandUnknownPreset(t *testing.T) {
+	_, err := ExpandPresets([]string{"IFD/Make", "not-a-preset"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown preset")
+	}
+}
+
+func TestPatternsFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	includedPaths := []string{
+		"IFD/Exif/*",
+		"IFD/GPSInfo/**",
+		"IFD1/*",
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, includedPaths[:], Options{})
+	scrubber.EnableThumbnailKeeping()
+
+	_, report, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keptThumbnailTag := false
+	for _, tag := range report.Tags {
+		path := tag.IfdPath + "/" + tag.TagName
+
+		if path == "IFD/Exif/FocalLengthIn35mmFilm" {
+			// Stored with a non-standard type in the fixture, so it can't be parsed and is
+			// always removed
+			continue
+		}
+
+		var wantKept bool
+		switch tag.IfdPath {
+		case "IFD/Exif", "IFD/GPSInfo", "IFD1":
+			wantKept = true
+		default:
+			// Neither root tags nor tags in "IFD/Exif/Iop" are matched by the patterns
+			wantKept = false
+		}
+
+		if tag.Removed == wantKept {
+			t.Errorf("tag %s: have removed %t, want removed %t", path, tag.Removed, !wantKept)
+		}
+
+		if tag.IfdPath == "IFD1" && !tag.Removed {
+			keptThumbnailTag = true
+		}
+	}
+
+	if !keptThumbnailTag {
+		t.Errorf("no tags from IFD1 were kept")
+	}
+}
+
+func TestGpsCoarseningFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Coarsening only applies to coordinates that are allowed
+	scrubber := NewExifScrubber([]uint16{}, []string{
+		"IFD/GPSInfo/GPSVersionID",
+		"IFD/GPSInfo/GPSLatitudeRef",
+		"IFD/GPSInfo/GPSLatitude",
+		"IFD/GPSInfo/GPSLongitudeRef",
+		"IFD/GPSInfo/GPSLongitude",
+		// Revealing a precise location, so removed nonetheless
+		"IFD/GPSInfo/GPSAltitude",
+	}, Options{})
+	scrubber.EnableGpsCoarsening(1)
+
+	updatedBuf, report, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coarsenedCount := 0
+	for _, tag := range report.Tags {
+		if tag.Coarsened {
+			coarsenedCount++
+		}
+	}
+
+	if coarsenedCount != 2 {
+		t.Errorf("have %d coarsened tags, want 2", coarsenedCount)
+	}
+
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(updatedBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootIfd, _, err := intfc.(*jis.SegmentList).Exif()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpsIfd, err := rootIfd.ChildWithIfdPath(exifcommon.IfdGpsInfoStandardIfdIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ite := range gpsIfd.Entries() {
+		switch ite.TagName() {
+		case "GPSVersionID", "GPSLatitudeRef", "GPSLongitudeRef":
+		case "GPSLatitude", "GPSLongitude":
+			value, err := ite.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dms := value.([]exifcommon.Rational)
+			if dms[0].Denominator != 10 || dms[1].Numerator != 0 || dms[2].Numerator != 0 {
+				t.Errorf("tag %s not coarsened to one decimal place: %v", ite.TagName(), dms)
+			}
+		default:
+			t.Errorf("tag %s kept although coarsening is enabled", ite.TagName())
+		}
+	}
+}
+
+func TestThumbnailFromFile(t *testing.T) {
+	// Cropped version of gps.jpg that still carries the thumbnail of the uncropped original
+	buf, err := ioutil.ReadFile("../fixtures/cropped.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootIfd, _, err := intfc.(*jis.SegmentList).Exif()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalThumbnail, err := rootIfd.NextIfd().Thumbnail()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	includedPaths := []string{"IFD/Orientation", "IFD1/*"}
+
+	// Per default, the thumbnail is removed along with IFD1
+	scrubber := NewExifScrubber([]uint16{}, includedPaths, Options{})
+
+	updatedBuf, report, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(updatedBuf, originalThumbnail) {
+		t.Errorf("original thumbnail still contained in scrubbed file")
+	}
+
+	for _, tag := range report.Tags {
+		if tag.IfdPath == "IFD1" && !tag.Removed {
+			t.Errorf("tag %s/%s reported as kept", tag.IfdPath, tag.TagName)
+		}
+	}
+
+	intfc, err = jis.NewJpegMediaParser().ParseBytes(updatedBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updatedRootIfd, _, err := intfc.(*jis.SegmentList).Exif()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updatedRootIfd.NextIfd() != nil {
+		t.Errorf("IFD1 still present in scrubbed file")
+	}
+
+	// If explicitly requested, the thumbnail is kept
+	scrubber.EnableThumbnailKeeping()
+
+	updatedBuf, _, err = scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(updatedBuf, originalThumbnail) {
+		t.Errorf("thumbnail removed although it should have been kept")
+	}
+}
+
+func TestOversizedTagValue(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Claim that the "Make" tag in IFD0 holds far more data than the file has
+	tiffHeader := bytes.Index(buf, []byte(exifJpegIdentifier)) + len(exifJpegIdentifier)
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	if buf[tiffHeader] == 'I' {
+		byteOrder = binary.LittleEndian
+	}
+
+	ifdOffset := tiffHeader + int(byteOrder.Uint32(buf[tiffHeader+4:]))
+	tagCount := int(byteOrder.Uint16(buf[ifdOffset:]))
+	patched := false
+	for i := 0; i < tagCount; i++ {
+		entryOffset := ifdOffset + ifdCountSize + i*ifdEntrySize
+		if byteOrder.Uint16(buf[entryOffset:]) == 0x010f {
+			byteOrder.PutUint32(buf[entryOffset+4:], 0x7fffffff)
+			patched = true
+		}
+	}
+
+	if !patched {
+		t.Fatal("Make tag not found")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{"IFD/Make"}, Options{})
+	_, _, err = scrubber.ScrubExif(buf)
+	if !errors.Is(err, ErrMalformedExif) {
+		t.Errorf("have error %v, want %v", err, ErrMalformedExif)
+	}
+}
+
+func TestScrubTruncatedJpeg(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Without the EOI marker, the JPEG parser does not recognize the file
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	_, _, err = scrubber.ScrubExif(buf[:len(buf)-2])
+	if !errors.Is(err, ErrMalformedImage) {
+		t.Errorf("have error %v, want %v", err, ErrMalformedImage)
+	}
+}
+
+func TestGpsCoarseningDenied(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	type tType struct {
+		name     string
+		scrubber ExifScrubber
+	}
+
+	tests := []tType{
+		{"allowlist without GPS", NewExifScrubber([]uint16{}, []string{}, Options{})},
+		{"denied GPS", NewDenyingExifScrubber([]uint16{}, []string{"IFD/GPSInfo/**"}, Options{})},
+		{"denied coordinates", NewDenyingExifScrubber(
+			[]uint16{}, []string{"IFD/GPSInfo/GPSLatitude", "IFD/GPSInfo/GPSLongitude"}, Options{},
+		)},
+	}
+
+	for _, test := range tests {
+		scrubber := test.scrubber
+		scrubber.EnableGpsCoarsening(1)
+		if scrubber.AllowsGpsCoordinates() {
+			t.Errorf("%s: GPS coordinates allowed", test.name)
+		}
+
+		_, report, err := scrubber.ScrubExif(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// An explicit deny wins over coarsening
+		for _, tag := range report.Tags {
+			isCoordinate := tag.Gps &&
+				(tag.TagId == gpsLatitudeTagId || tag.TagId == gpsLongitudeTagId)
+			if tag.Coarsened || (isCoordinate && !tag.Removed) {
+				t.Errorf("%s: tag %s kept", test.name, tag.TagName)
+			}
+		}
+
+		// The original coordinates must not pass either
+		err = scrubber.Verify(buf)
+		if !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("%s: have error %v, want %v", test.name, err, ErrVerificationFailed)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package exifscrubber
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+var fuzzFixtures = []string{
+	"gps.jpg",
+	"gps.png",
+	"cropped.jpg",
+	"makernote-canon.jpg",
+	"makernote-nikon.jpg",
+	"makernote-sony.jpg",
+}
+
+// Scrubber configurations exercised by the fuzz targets
+func fuzzScrubbers() map[string]*ExifScrubber {
+	allowing := NewExifScrubber([]uint16{}, []string{"IFD/Orientation", "IFD/Exif/*"}, Options{})
+
+	denying := NewDenyingExifScrubber([]uint16{}, []string{"IFD/Artist"}, Options{})
+
+	coarsening := NewExifScrubber([]uint16{}, []string{"IFD/GPSInfo/**"}, Options{})
+	coarsening.EnableGpsCoarsening(2)
+	coarsening.EnableThumbnailKeeping()
+
+	baking := NewExifScrubber([]uint16{}, []string{}, Options{})
+	baking.EnableOrientationBaking(DefaultJpegQuality)
+	// Keeps each run fast, the limit itself is what protects against fuzzed image dimensions
+	baking.maxBakedPixels = 1 << 20
+
+	return map[string]*ExifScrubber{
+		"allowlist":  &allowing,
+		"denylist":   &denying,
+		"coarsening": &coarsening,
+		"baking":     &baking,
+	}
+}
+
+func addFuzzFixtures(f *testing.F) {
+	for _, fixture := range fuzzFixtures {
+		buf, err := ioutil.ReadFile("../fixtures/" + fixture)
+		if err != nil {
+			f.Fatalf("could not open file %s", fixture)
+		}
+
+		f.Add(buf)
+	}
+}
+
+func FuzzScrubExif(f *testing.F) {
+	addFuzzFixtures(f)
+	scrubbers := fuzzScrubbers()
+
+	f.Fuzz(func(t *testing.T, fileData []byte) {
+		for name, scrubber := range scrubbers {
+			scrubbed, report, err := scrubber.ScrubExif(fileData)
+			if err != nil {
+				continue
+			}
+
+			if report == nil {
+				t.Fatalf("%s: no report despite success", name)
+			}
+
+			// The output must re-parse and pass verification on its own
+			_, _, err = parseExif(scrubbed)
+			if err != nil {
+				t.Fatalf("%s: scrubbed output does not parse: %s", name, err)
+			}
+
+			err = scrubber.Verify(scrubbed)
+			if err != nil {
+				t.Fatalf("%s: scrubbed output does not verify: %s", name, err)
+			}
+
+			// Scrubbing is idempotent in the sense that scrubbed output can be scrubbed again
+			_, _, err = scrubber.ScrubExif(scrubbed)
+			if err != nil {
+				t.Fatalf("%s: scrubbed output cannot be scrubbed again: %s", name, err)
+			}
+		}
+	})
+}
+
+func FuzzStripMetadata(f *testing.F) {
+	addFuzzFixtures(f)
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+	f.Fuzz(func(t *testing.T, fileData []byte) {
+		stripped, err := scrubber.StripMetadata(fileData)
+		if err != nil {
+			return
+		}
+
+		err = scrubber.Verify(stripped)
+		if err != nil {
+			t.Fatalf("stripped output does not verify: %s", err)
+		}
+	})
+}
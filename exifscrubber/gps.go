@@ -0,0 +1,153 @@
+package exifscrubber
+
+import (
+	"errors"
+	"math"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+const (
+	gpsVersionIdTagId    = 0x0000
+	gpsLatitudeRefTagId  = 0x0001
+	gpsLatitudeTagId     = 0x0002
+	gpsLongitudeRefTagId = 0x0003
+	gpsLongitudeTagId    = 0x0004
+)
+
+// Highest supported number of decimal places for coarsened coordinates (roughly 0.1 m)
+const MaxGpsDecimals = 6
+
+var ErrInvalidGpsCoordinate = errors.New("invalid GPS coordinate")
+
+// GPS tags that are always removed when coarsening since they either reveal a more precise
+// location on their own or are not needed once the coordinates are coarsened
+var preciseGpsTagIds = map[uint16]struct{}{
+	0x0005: {}, // GPSAltitudeRef
+	0x0006: {}, // GPSAltitude
+	0x000b: {}, // GPSDOP
+	0x000c: {}, // GPSSpeedRef
+	0x000d: {}, // GPSSpeed
+	0x000e: {}, // GPSTrackRef
+	0x000f: {}, // GPSTrack
+	0x0010: {}, // GPSImgDirectionRef
+	0x0011: {}, // GPSImgDirection
+	0x0013: {}, // GPSDestLatitudeRef
+	0x0014: {}, // GPSDestLatitude
+	0x0015: {}, // GPSDestLongitudeRef
+	0x0016: {}, // GPSDestLongitude
+	0x0017: {}, // GPSDestBearingRef
+	0x0018: {}, // GPSDestBearing
+	0x0019: {}, // GPSDestDistanceRef
+	0x001a: {}, // GPSDestDistance
+	0x001b: {}, // GPSProcessingMethod
+	0x001c: {}, // GPSAreaInformation
+	0x001e: {}, // GPSDifferential
+}
+
+// Handles a tag of the GPS IFD when coarsening is enabled. Coordinates are rounded to the
+// configured precision and added to `ib`, tags revealing a precise location are dropped. The
+// coordinates and the tags required to interpret them are only kept if the regular allow/deny
+// rules keep them, so an explicit deny wins over coarsening. Returns whether the tag has been
+// handled; if not, the regular allow/deny rules apply.
+func (scrubber *ExifScrubber) coarsenGpsTag(
+	ib *exif.IfdBuilder,
+	ifd *exif.Ifd,
+	ite *exif.IfdTagEntry,
+	rawExif []byte,
+	report *ScrubReport,
+) (handled bool, err error) {
+	switch ite.TagId() {
+	case gpsVersionIdTagId, gpsLatitudeRefTagId, gpsLatitudeTagId, gpsLongitudeRefTagId,
+		gpsLongitudeTagId:
+		if !scrubber.isTagAllowed(ite) {
+			report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), true)
+			return true, nil
+		}
+	}
+
+	switch ite.TagId() {
+	case gpsLatitudeTagId, gpsLongitudeTagId:
+		value, err := tagValue(ite, rawExif)
+		if err != nil {
+			return false, err
+		}
+
+		coarsened, err := coarsenCoordinate(value, scrubber.gpsDecimals)
+		if err != nil {
+			return false, err
+		}
+
+		err = ib.AddStandard(ite.TagId(), coarsened)
+		if err != nil {
+			return false, err
+		}
+
+		report.addCoarsened(ifd.IfdIdentity(), ite.TagId(), ite.TagName())
+		return true, nil
+	case gpsVersionIdTagId, gpsLatitudeRefTagId, gpsLongitudeRefTagId:
+		// Required to interpret the coarsened coordinates
+		bt, err := builderTagFromExisting(ifd, ite, rawExif)
+		if err != nil {
+			return false, err
+		}
+
+		err = ib.Add(bt)
+		if err != nil {
+			return false, err
+		}
+
+		report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), false)
+		return true, nil
+	}
+
+	if _, precise := preciseGpsTagIds[ite.TagId()]; precise {
+		report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), true)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Whether the allow/deny rules keep at least one of the GPS coordinates. If not, enabling GPS
+// coarsening has no effect.
+func (scrubber *ExifScrubber) AllowsGpsCoordinates() bool {
+	for tagId, tagName := range map[uint16]string{
+		gpsLatitudeTagId:  "GPSLatitude",
+		gpsLongitudeTagId: "GPSLongitude",
+	} {
+		listed := scrubber.isTagListed(exifcommon.IfdGpsInfoStandardIfdIdentity, tagId, tagName)
+		if listed != (scrubber.mode == ModeDenylist) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rounds a degrees/minutes/seconds coordinate to `decimals` decimal places of a degree. The result
+// is encoded as fractional degrees with zero minutes and seconds.
+func coarsenCoordinate(value interface{}, decimals int) ([]exifcommon.Rational, error) {
+	dms, ok := value.([]exifcommon.Rational)
+	if !ok || len(dms) != 3 {
+		return nil, ErrInvalidGpsCoordinate
+	}
+
+	degrees := 0.0
+	for i, divisor := range []float64{1, 60, 3600} {
+		if dms[i].Denominator == 0 {
+			return nil, ErrInvalidGpsCoordinate
+		}
+
+		degrees += float64(dms[i].Numerator) / float64(dms[i].Denominator) / divisor
+	}
+
+	scale := math.Pow10(decimals)
+
+	return []exifcommon.Rational{
+		{Numerator: uint32(math.Round(degrees * scale)), Denominator: uint32(scale)},
+		{Numerator: 0, Denominator: 1},
+		{Numerator: 0, Denominator: 1},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package exifscrubber
+
+import (
+	"bytes"
+)
+
+// Identifies APP2 segments holding (a part of) an ICC profile
+const iccProfileJpegIdentifier = "ICC_PROFILE\x00"
+
+// Ancillary PNG chunks describing the color space of the image
+var pngColorChunks = map[string]struct{}{
+	"gAMA":       {},
+	"cHRM":       {},
+	"sRGB":       {},
+	pngChunkIccp: {},
+	"cICP":       {},
+}
+
+// Also remove ICC color profiles (JPEG APP2 "ICC_PROFILE" segments and PNG iCCP chunks). Per
+// default, ICC profiles are always kept since removing them may change how an image looks.
+func (scrubber *ExifScrubber) EnableIccProfileStripping() {
+	scrubber.stripIccProfile = true
+}
+
+func isIccJpegSegment(marker byte, segment []byte) bool {
+	return marker == jpegMarkerApp2 &&
+		bytes.HasPrefix(jpegSegmentPayload(segment), []byte(iccProfileJpegIdentifier))
+}
+
+// Removes ICC profiles from scrubbed file data if configured to do so
+func (scrubber *ExifScrubber) applyIccPolicy(fileData []byte) ([]byte, error) {
+	if !scrubber.stripIccProfile {
+		return fileData, nil
+	}
+
+	if bytes.HasPrefix(fileData, pngSignature) {
+		return filterPngChunks(fileData, func(chunkType string, chunk []byte) bool {
+			return chunkType != pngChunkIccp
+		})
+	}
+
+	return filterJpegSegments(fileData, func(marker byte, segment []byte) bool {
+		return !isIccJpegSegment(marker, segment)
+	})
+}
+
+// Copies the color information of `original` into `reencoded`, which is expected to be the same
+// image in the same format but without any metadata, e.g., as produced by the standard library
+// encoders. ICC profiles are not copied if configured to strip them.
+func (scrubber *ExifScrubber) copyColorInformation(original []byte, reencoded []byte) ([]byte, error) {
+	colorData := new(bytes.Buffer)
+
+	if bytes.HasPrefix(original, pngSignature) {
+		err := forEachPngChunk(original, func(chunkType string, chunk []byte) {
+			if _, isColorChunk := pngColorChunks[chunkType]; !isColorChunk {
+				return
+			}
+
+			if scrubber.stripIccProfile && chunkType == pngChunkIccp {
+				return
+			}
+
+			colorData.Write(chunk)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Color chunks must precede the image data, so insert them right after IHDR
+		var ihdrEnd int
+		err = forEachPngChunk(reencoded, func(chunkType string, chunk []byte) {
+			if chunkType == pngChunkIhdr {
+				ihdrEnd = len(pngSignature) + len(chunk)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return concat(reencoded[:ihdrEnd], colorData.Bytes(), reencoded[ihdrEnd:]), nil
+	}
+
+	if scrubber.stripIccProfile {
+		// There is no other color information we could copy for JPEG files
+		return reencoded, nil
+	}
+
+	_, err := forEachJpegSegment(original, func(marker byte, segment []byte) {
+		if isIccJpegSegment(marker, segment) {
+			colorData.Write(segment)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert right after SOI
+	return concat(reencoded[:2], colorData.Bytes(), reencoded[2:]), nil
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
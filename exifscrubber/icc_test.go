@@ -0,0 +1,155 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+)
+
+// Stand-in for an ICC profile. Decoders don't interpret the profile, so its content is irrelevant.
+var iccProfile = []byte("synthetic ICC profile for testing purposes")
+
+// Returns the JPEG fixture with an APP2 ICC profile segment inserted after SOI along with the raw
+// segment
+func jpegWithIccProfile(t *testing.T) ([]byte, []byte) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Sequence number and total number of segments follow the identifier
+	payload := concat([]byte(iccProfileJpegIdentifier), []byte{1, 1}, iccProfile)
+
+	segment := []byte{jpegMarkerPrefix, jpegMarkerApp2, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	return concat(buf[:2], segment, buf[2:]), segment
+}
+
+// Returns the PNG fixture with an iCCP chunk inserted after IHDR along with the raw chunk
+func pngWithIccProfile(t *testing.T) ([]byte, []byte) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.png")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	compressed := new(bytes.Buffer)
+	w := zlib.NewWriter(compressed)
+	w.Write(iccProfile)
+	w.Close()
+
+	// Profile name, null separator and compression method precede the compressed profile
+	data := concat([]byte("test\x00\x00"), compressed.Bytes())
+
+	chunk := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = concat(chunk, []byte(pngChunkIccp), data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	// Signature (8 bytes) and IHDR chunk (25 bytes)
+	ihdrEnd := len(pngSignature) + 25
+	return concat(buf[:ihdrEnd], chunk, buf[ihdrEnd:]), chunk
+}
+
+func TestScrubPreservesPixels(t *testing.T) {
+	for _, fixture := range []string{"gps.jpg", "gps.png"} {
+		buf, err := ioutil.ReadFile("../fixtures/" + fixture)
+		if err != nil {
+			t.Fatalf("could not open file %s", fixture)
+		}
+
+		scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+		updatedBuf, _, err := scrubber.ScrubExif(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertSamePixels(t, updatedBuf, buf)
+	}
+}
+
+func TestIccProfilePreserved(t *testing.T) {
+	jpegBuf, jpegSegment := jpegWithIccProfile(t)
+	pngBuf, pngChunk := pngWithIccProfile(t)
+
+	type tType struct {
+		name       string
+		fileData   []byte
+		iccProfile []byte
+	}
+
+	tests := []tType{
+		{name: "jpeg", fileData: jpegBuf, iccProfile: jpegSegment},
+		{name: "png", fileData: pngBuf, iccProfile: pngChunk},
+	}
+
+	for _, test := range tests {
+		scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+		updatedBuf, _, err := scrubber.ScrubExif(test.fileData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Contains(updatedBuf, test.iccProfile) {
+			t.Errorf("%s: ICC profile removed by scrubbing", test.name)
+		}
+
+		assertSamePixels(t, updatedBuf, test.fileData)
+
+		strippedBuf, err := scrubber.StripMetadata(test.fileData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Contains(strippedBuf, test.iccProfile) {
+			t.Errorf("%s: ICC profile removed by stripping", test.name)
+		}
+
+		// ICC profiles are only removed if explicitly requested
+		scrubber.EnableIccProfileStripping()
+
+		updatedBuf, _, err = scrubber.ScrubExif(test.fileData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(updatedBuf, test.iccProfile) {
+			t.Errorf("%s: ICC profile kept although stripping was requested", test.name)
+		}
+
+		assertSamePixels(t, updatedBuf, test.fileData)
+
+		strippedBuf, err = scrubber.StripMetadata(test.fileData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(strippedBuf, test.iccProfile) {
+			t.Errorf("%s: ICC profile kept by stripping although removal was requested", test.name)
+		}
+	}
+}
+
+func TestIccProfileCopiedWhenBakingOrientation(t *testing.T) {
+	buf, segment := jpegWithIccProfile(t)
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	scrubber.EnableOrientationBaking(DefaultJpegQuality)
+
+	reencoded, err := scrubber.reencodeOriented(buf, orientationRotate180)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(reencoded, segment) {
+		t.Errorf("ICC profile lost when re-encoding")
+	}
+}
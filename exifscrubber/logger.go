@@ -0,0 +1,53 @@
+package exifscrubber
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives warnings about problems that do not prevent scrubbing. `args` are alternating
+// keys and values, so a *slog.Logger can be used directly.
+type Logger interface {
+	Warn(msg string, args ...any)
+}
+
+// Options configure a scrubber at construction time
+type Options struct {
+	// Receives warnings. Defaults to the standard logger of the log package.
+	Logger Logger
+}
+
+type logLogger struct {
+	logger *log.Logger
+}
+
+// Creates a Logger writing to `logger`. Key-value pairs are appended to the message as
+// "key=value".
+func NewLogLogger(logger *log.Logger) Logger {
+	return &logLogger{logger: logger}
+}
+
+func (l *logLogger) Warn(msg string, args ...any) {
+	var b strings.Builder
+	b.WriteString("warning: ")
+	b.WriteString(msg)
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+
+	l.logger.Println(b.String())
+}
+
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return NewLogLogger(log.Default())
+	}
+
+	return logger
+}
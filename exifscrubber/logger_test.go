@@ -0,0 +1,130 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	exif "github.com/dsoprea/go-exif/v3"
+)
+
+func TestLogLogger(t *testing.T) {
+	b := new(bytes.Buffer)
+	logger := NewLogLogger(log.New(b, "jaf > ", 0))
+
+	logger.Warn("child IFD does not match", "ifdPath", "IFD", "tagPosition", 3, "dangling")
+
+	want := "jaf > warning: child IFD does not match ifdPath=IFD tagPosition=3 dangling\n"
+	if b.String() != want {
+		t.Errorf("have %q, want %q", b.String(), want)
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	if scrubber.logger == nil {
+		t.Error("no default logger set")
+	}
+}
+
+// Records the messages of all warnings
+type recordingLogger struct {
+	warnings []string
+}
+
+func (l *recordingLogger) Warn(msg string, args ...any) {
+	l.warnings = append(l.warnings, msg)
+}
+
+func TestChildIfdMismatchIsLogged(t *testing.T) {
+	fileData, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootIfd, _, err := parseExif(fileData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gpsIfd *exif.Ifd
+	for _, childIfd := range rootIfd.Children() {
+		if childIfd.IfdIdentity().TagId() == 0x8825 {
+			gpsIfd = childIfd
+		}
+	}
+	if gpsIfd == nil {
+		t.Fatal("fixture has no GPS IFD")
+	}
+
+	logger := &recordingLogger{}
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{Logger: logger})
+
+	// Pretend the tag pointing to the GPS IFD was the one of the Exif IFD
+	childIfd, err := scrubber.childIfdOf(
+		rootIfd.IfdIdentity().String(),
+		rootIfd.Children(),
+		0x8769,
+		gpsIfd.ParentTagIndex(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if childIfd != gpsIfd {
+		t.Error("wrong child IFD returned")
+	}
+
+	if len(logger.warnings) != 1 {
+		t.Fatalf("have warnings %q, want a single one on the injected logger", logger.warnings)
+	}
+}
+
+func TestMissingChildIfd(t *testing.T) {
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+	// The tag at position 3 points to a child IFD that was not parsed
+	_, err := scrubber.childIfdOf("IFD", []*exif.Ifd{}, 0x8769, 3)
+
+	var childIfdErr *ChildIfdError
+	if !errors.As(err, &childIfdErr) {
+		t.Fatalf("have error %v, want ChildIfdError", err)
+	}
+	if childIfdErr.IfdPath != "IFD" || childIfdErr.TagId != 0x8769 ||
+		childIfdErr.TagPosition != 3 {
+		t.Errorf("have %+v, want the IFD, ID and position of the tag", childIfdErr)
+	}
+}
+
+func TestDanglingChildIfdPointer(t *testing.T) {
+	// Big-endian TIFF header followed by IFD0 holding only an Exif IFD pointer past the end of the
+	// data
+	tiff := []byte{'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08}
+	tiff = append(tiff, 0x00, 0x01)
+	tiff = append(tiff, 0x87, 0x69, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0xff, 0xff, 0xf0)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+
+	b := new(bytes.Buffer)
+	if err := jpeg.Encode(b, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert an APP1 segment holding the EXIF right after the start-of-image marker
+	payload := append([]byte(exifJpegIdentifier), tiff...)
+	fileData := append([]byte{}, b.Bytes()[:2]...)
+	fileData = append(fileData, 0xff, 0xe1, byte((len(payload)+2)>>8), byte(len(payload)+2))
+	fileData = append(fileData, payload...)
+	fileData = append(fileData, b.Bytes()[2:]...)
+
+	// Neither mode may keep the tags of an IFD it could not look at
+	allowing := NewExifScrubber([]uint16{}, []string{}, Options{})
+	denying := NewDenyingExifScrubber([]uint16{}, []string{}, Options{})
+	for _, scrubber := range []ExifScrubber{allowing, denying} {
+		if _, _, err := scrubber.ScrubExif(fileData); err == nil {
+			t.Errorf("%s: dangling child IFD pointer accepted", scrubber.mode)
+		}
+	}
+}
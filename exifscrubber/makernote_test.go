@@ -0,0 +1,203 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	jis "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+func TestMakerNotesFromFiles(t *testing.T) {
+	type tType struct {
+		fixture string
+		// Tags that cannot be parsed. They are removed in allowlist mode and kept unmodified in
+		// denylist mode.
+		unparsedTagIds []uint16
+		// Tags that cannot be parsed and hold a single LONG, which may be an offset. They are
+		// removed in both modes.
+		offsetTagIds []uint16
+	}
+
+	tests := []tType{
+		{fixture: "makernote-canon.jpg"},
+		{ // private tag in IFD0
+			fixture:        "makernote-nikon.jpg",
+			unparsedTagIds: []uint16{0xfde8},
+		},
+		{ // pointer to a non-standard IFD and a standard tag stored as LONG
+			fixture:      "makernote-sony.jpg",
+			offsetTagIds: []uint16{0xc634, 0x0112},
+		},
+	}
+
+	scrubbers := map[string]ExifScrubber{
+		"allowlist": NewExifScrubber([]uint16{}, []string{"IFD/**"}, Options{}),
+		"denylist":  NewDenyingExifScrubber([]uint16{}, []string{}, Options{}),
+	}
+
+	for _, test := range tests {
+		buf, err := ioutil.ReadFile("../fixtures/" + test.fixture)
+		if err != nil {
+			t.Fatalf("could not open file %s", test.fixture)
+		}
+
+		for mode, scrubber := range scrubbers {
+			updatedBuf, report, err := scrubber.ScrubExif(buf)
+			if err != nil {
+				t.Errorf("%s (%s): %s", test.fixture, mode, err)
+				continue
+			}
+
+			// Maker note contents must be gone
+			for _, secret := range []string{"SERIAL-0123456789", "OWNER-JANE-DOE"} {
+				if bytes.Contains(updatedBuf, []byte(secret)) {
+					t.Errorf("%s (%s): maker note content %q still present", test.fixture, mode, secret)
+				}
+			}
+
+			removedIds := map[uint16]bool{}
+			for _, tag := range report.Tags {
+				removedIds[tag.TagId] = tag.Removed
+			}
+
+			if !removedIds[makerNoteTagId] {
+				t.Errorf("%s (%s): maker note not reported as removed", test.fixture, mode)
+			}
+
+			keptIds := unparsedTagIdsOf(t, updatedBuf)
+			for _, tagId := range append(test.unparsedTagIds, test.offsetTagIds...) {
+				wantRemoved := mode == "allowlist" || !containsTagId(test.unparsedTagIds, tagId)
+				if removed, found := removedIds[tagId]; !found || removed != wantRemoved {
+					t.Errorf("%s (%s): tag 0x%04x not reported, or reported as removed: %t",
+						test.fixture, mode, tagId, removed)
+				}
+
+				if keptIds[tagId] == wantRemoved {
+					t.Errorf("%s (%s): tag 0x%04x present in scrubbed file: %t",
+						test.fixture, mode, tagId, keptIds[tagId])
+				}
+			}
+
+			// The scrubbed file must still be a valid image with the same structure
+			original, err := jis.NewJpegMediaParser().ParseBytes(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updated, err := jis.NewJpegMediaParser().ParseBytes(updatedBuf)
+			if err != nil {
+				t.Errorf("%s (%s): scrubbed file can't be parsed: %s", test.fixture, mode, err)
+				continue
+			}
+
+			originalSegments := original.(*jis.SegmentList).Segments()
+			updatedSegments := updated.(*jis.SegmentList).Segments()
+			if len(originalSegments) != len(updatedSegments) {
+				t.Errorf("%s (%s): have %d segments, want %d",
+					test.fixture, mode, len(updatedSegments), len(originalSegments))
+			}
+
+			_, _, err = updated.(*jis.SegmentList).Exif()
+			if err != nil {
+				t.Errorf("%s (%s): scrubbed EXIF can't be parsed: %s", test.fixture, mode, err)
+			}
+
+			_, _, err = image.Decode(bytes.NewReader(updatedBuf))
+			if err != nil {
+				t.Errorf("%s (%s): scrubbed image can't be decoded: %s", test.fixture, mode, err)
+			}
+		}
+	}
+}
+
+// Returns the IDs of all tags in IFD0 of `fileData` that the parser skips
+func unparsedTagIdsOf(t *testing.T, fileData []byte) map[uint16]bool {
+	rootIfd, rawExif, err := parseExif(fileData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagIndex := exif.NewTagIndex()
+	err = exif.LoadStandardTags(tagIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagIds := map[uint16]bool{}
+	for _, tag := range unparsedTagsOf(rootIfd, rawExif, tagIndex) {
+		tagIds[tag.tagId] = true
+	}
+
+	return tagIds
+}
+
+func containsTagId(tagIds []uint16, tagId uint16) bool {
+	for _, id := range tagIds {
+		if id == tagId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDeniedUnparsedTags(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/makernote-nikon.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Private tags have no name, but can still be denied by ID
+	scrubber := NewDenyingExifScrubber([]uint16{0xfde8}, []string{}, Options{})
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keptIds := unparsedTagIdsOf(t, updatedBuf)
+	if len(keptIds) != 0 {
+		t.Errorf("have unparsed tags %v, want none", keptIds)
+	}
+
+	// The original has the tag, which is not allowed to pass
+	err = scrubber.Verify(buf)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("have error %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+func TestVerifyUnparsedOffsetTags(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/makernote-sony.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// The pointer to the private IFD must not be passed through, even if nothing is denied
+	scrubber := NewDenyingExifScrubber([]uint16{}, []string{}, Options{})
+	err = scrubber.Verify(buf)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("have error %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+func TestUnparsedTagValue(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/makernote-nikon.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewDenyingExifScrubber([]uint16{}, []string{}, Options{})
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The private tag is too large to be stored inline, so its value has been moved
+	if !bytes.Contains(updatedBuf, []byte("Nikon private data")) {
+		t.Error("value of private tag lost")
+	}
+}
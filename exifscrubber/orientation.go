@@ -0,0 +1,211 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exiflog "github.com/dsoprea/go-logging"
+)
+
+const orientationTagId = 0x0112
+
+// Orientation values as defined by the EXIF standard
+const (
+	orientationUnsupported = 0
+	orientationNormal      = 1
+	orientationFlipH       = 2
+	orientationRotate180   = 3
+	orientationFlipV       = 4
+	orientationTranspose   = 5
+	orientationRotate90    = 6
+	orientationTransverse  = 7
+	orientationRotate270   = 8
+)
+
+// Largest image, in pixels, whose orientation is baked. Decoding and re-encoding needs several
+// bytes per pixel, so larger images are rejected before decoding them instead of risking running
+// out of memory on a small file declaring huge dimensions.
+const MaxBakedPixels = 50 * 1000 * 1000
+
+var ErrImageTooLarge = errors.New("image too large to apply its orientation")
+
+const (
+	DefaultJpegQuality = 90
+	minJpegQuality     = 1
+	maxJpegQuality     = 100
+)
+
+// Format names as returned by image.Decode()
+const (
+	imageFormatJpeg = "jpeg"
+	imageFormatPng  = "png"
+)
+
+// Instead of filtering tags, apply the orientation stored in EXIF to the pixel data and remove all
+// EXIF afterwards. Images that need to be rotated or flipped are re-encoded; JPEG images use
+// `jpegQuality` (clamped to [1, 100]) in this case.
+func (scrubber *ExifScrubber) EnableOrientationBaking(jpegQuality int) {
+	if jpegQuality < minJpegQuality {
+		jpegQuality = minJpegQuality
+	} else if jpegQuality > maxJpegQuality {
+		jpegQuality = maxJpegQuality
+	}
+
+	scrubber.bakeOrientation = true
+	scrubber.jpegQuality = jpegQuality
+	scrubber.maxBakedPixels = MaxBakedPixels
+}
+
+// Removes all EXIF from an image and applies its orientation to the pixel data. `dropExif` is
+// called to remove EXIF without re-encoding the image if no transformation is required.
+func (scrubber *ExifScrubber) bakeOrientationAndStrip(
+	fileData []byte,
+	rootIfd *exif.Ifd,
+	rawExif []byte,
+	report *ScrubReport,
+	dropExif func() ([]byte, error),
+) ([]byte, *ScrubReport, error) {
+	orientation, err := orientationOf(rootIfd, rawExif)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var scrubbedData []byte
+	if orientation > orientationNormal && orientation <= orientationRotate270 {
+		scrubbedData, err = scrubber.reencodeOriented(fileData, orientation)
+	} else {
+		scrubbedData, err = dropExif()
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report.addRemovedChain(rootIfd)
+	return scrubbedData, report, nil
+}
+
+// Returns the orientation stored in the first IFD or `orientationNormal` if there is none
+func orientationOf(rootIfd *exif.Ifd, rawExif []byte) (uint16, error) {
+	results, err := rootIfd.FindTagWithId(orientationTagId)
+	if err != nil {
+		if exiflog.Is(err, exif.ErrTagNotFound) {
+			return orientationNormal, nil
+		}
+
+		return orientationUnsupported, err
+	}
+
+	value, err := tagValue(results[0], rawExif)
+	if err != nil {
+		return orientationUnsupported, err
+	}
+
+	orientations, ok := value.([]uint16)
+	if !ok || len(orientations) == 0 {
+		return orientationUnsupported, nil
+	}
+
+	return orientations[0], nil
+}
+
+// Decodes `fileData`, transforms the pixels according to `orientation` and encodes the result in
+// the same format again. The encoded image does not contain any metadata.
+func (scrubber *ExifScrubber) reencodeOriented(fileData []byte, orientation uint16) ([]byte, error) {
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(fileData))
+	if err != nil {
+		return nil, err
+	}
+
+	pixels := int64(imgConfig.Width) * int64(imgConfig.Height)
+	if pixels > int64(scrubber.maxBakedPixels) {
+		return nil, fmt.Errorf(
+			"%w: %dx%d pixels, the limit is %d",
+			ErrImageTooLarge,
+			imgConfig.Width,
+			imgConfig.Height,
+			scrubber.maxBakedPixels,
+		)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(fileData))
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(bytes.Buffer)
+	switch format {
+	case imageFormatJpeg:
+		oriented := image.NewRGBA(orientedBounds(img.Bounds(), orientation))
+		applyOrientation(oriented, img, orientation)
+
+		err = jpeg.Encode(b, oriented, &jpeg.Options{Quality: scrubber.jpegQuality})
+	case imageFormatPng:
+		// Use a 16-bit image so we don't lose any color depth
+		oriented := image.NewNRGBA64(orientedBounds(img.Bounds(), orientation))
+		applyOrientation(oriented, img, orientation)
+
+		err = png.Encode(b, oriented)
+	default:
+		return nil, ErrUnknownFileType
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The encoders don't write any color information, so we need to carry it over to make sure
+	// the image still looks the same
+	return scrubber.copyColorInformation(fileData, b.Bytes())
+}
+
+// Returns the bounds of an image with `bounds` after applying `orientation`
+func orientedBounds(bounds image.Rectangle, orientation uint16) image.Rectangle {
+	if orientation >= orientationTranspose {
+		// Width and height are swapped
+		return image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+	}
+
+	return image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+}
+
+// Draws `src` onto `dst` so that `dst` displays correctly without taking `orientation` into
+// account. `dst` must have the bounds returned by orientedBounds().
+func applyOrientation(dst draw.Image, src image.Image, orientation uint16) {
+	srcBounds := src.Bounds()
+	w, h := srcBounds.Dx(), srcBounds.Dy()
+	dstBounds := dst.Bounds()
+
+	for y := 0; y < dstBounds.Dy(); y++ {
+		for x := 0; x < dstBounds.Dx(); x++ {
+			var srcX, srcY int
+
+			switch orientation {
+			case orientationFlipH:
+				srcX, srcY = w-1-x, y
+			case orientationRotate180:
+				srcX, srcY = w-1-x, h-1-y
+			case orientationFlipV:
+				srcX, srcY = x, h-1-y
+			case orientationTranspose:
+				srcX, srcY = y, x
+			case orientationRotate90:
+				srcX, srcY = y, h-1-x
+			case orientationTransverse:
+				srcX, srcY = w-1-y, h-1-x
+			case orientationRotate270:
+				srcX, srcY = w-1-y, x
+			default:
+				srcX, srcY = x, y
+			}
+
+			dst.Set(x, y, src.At(srcBounds.Min.X+srcX, srcBounds.Min.Y+srcY))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"testing"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	jis "github.com/dsoprea/go-jpeg-image-structure/v2"
+	exiflog "github.com/dsoprea/go-logging"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1 image with a red pixel on the left and a blue pixel on the right
+	red := color.RGBA{R: 0xff, A: 0xff}
+	blue := color.RGBA{B: 0xff, A: 0xff}
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	type tType struct {
+		orientation uint16
+		// Expected colors of the oriented image, row by row
+		expected [][]color.RGBA
+	}
+
+	tests := []tType{
+		{orientation: orientationNormal, expected: [][]color.RGBA{{red, blue}}},
+		{orientation: orientationFlipH, expected: [][]color.RGBA{{blue, red}}},
+		{orientation: orientationRotate180, expected: [][]color.RGBA{{blue, red}}},
+		{orientation: orientationFlipV, expected: [][]color.RGBA{{red, blue}}},
+		{orientation: orientationTranspose, expected: [][]color.RGBA{{red}, {blue}}},
+		{orientation: orientationRotate90, expected: [][]color.RGBA{{red}, {blue}}},
+		{orientation: orientationTransverse, expected: [][]color.RGBA{{blue}, {red}}},
+		{orientation: orientationRotate270, expected: [][]color.RGBA{{blue}, {red}}},
+	}
+
+	for _, test := range tests {
+		dst := image.NewRGBA(orientedBounds(src.Bounds(), test.orientation))
+		applyOrientation(dst, src, test.orientation)
+
+		if dst.Bounds().Dy() != len(test.expected) || dst.Bounds().Dx() != len(test.expected[0]) {
+			t.Errorf("orientation %d: unexpected bounds %v", test.orientation, dst.Bounds())
+			continue
+		}
+
+		for y, row := range test.expected {
+			for x, want := range row {
+				if have := dst.RGBAAt(x, y); have != want {
+					t.Errorf("orientation %d: pixel (%d, %d) is %v, want %v",
+						test.orientation, x, y, have, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBakeOrientationFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Rewrite the fixture so that it needs to be rotated by 90 degrees
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sl := intfc.(*jis.SegmentList)
+	rootIb, err := sl.ConstructExifBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rootIb.SetStandardWithName("Orientation", []uint16{orientationRotate90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sl.SetExif(rootIb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotatedBuf := new(bytes.Buffer)
+	err = sl.Write(rotatedBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{"IFD/Orientation"}, Options{})
+	scrubber.EnableOrientationBaking(DefaultJpegQuality)
+
+	updatedBuf, report, err := scrubber.ScrubExif(rotatedBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.KeptCount() != 0 {
+		t.Errorf("have %d kept tags, want 0", report.KeptCount())
+	}
+
+	baked, _, err := image.DecodeConfig(bytes.NewReader(updatedBuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if baked.Width != original.Height || baked.Height != original.Width {
+		t.Errorf("have %dx%d image, want %dx%d",
+			baked.Width, baked.Height, original.Height, original.Width)
+	}
+
+	intfc, err = jis.NewJpegMediaParser().ParseBytes(updatedBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = intfc.(*jis.SegmentList).Exif()
+	if !exiflog.Is(err, exif.ErrNoExif) {
+		t.Errorf("EXIF still present after baking orientation: %v", err)
+	}
+}
+
+func TestBakeOrientationRejectsHugeImages(t *testing.T) {
+	b := new(bytes.Buffer)
+	if err := jpeg.Encode(b, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Declare 60000x60000 pixels in the start-of-frame segment: marker, length and precision are
+	// followed by the height and width
+	fileData := b.Bytes()
+	sof := bytes.Index(fileData, []byte{0xff, 0xc0})
+	if sof < 0 {
+		t.Fatal("no start-of-frame segment")
+	}
+	binary.BigEndian.PutUint16(fileData[sof+5:], 60000)
+	binary.BigEndian.PutUint16(fileData[sof+7:], 60000)
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	scrubber.EnableOrientationBaking(DefaultJpegQuality)
+
+	_, err := scrubber.reencodeOriented(fileData, orientationRotate90)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("have error %v, want ErrImageTooLarge", err)
+	}
+}
@@ -0,0 +1,111 @@
+package exifscrubber
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	pathSeparator = "/"
+	// Matches any number of path segments, including none
+	recursiveWildcard = "**"
+)
+
+// Matches tag paths against a set of exact paths and glob patterns. Patterns are split into
+// segments once so matching a tag path does not need to re-parse them.
+//
+// Each segment of a pattern may use the syntax of path.Match (e.g., "*" or "GPS*"). A segment
+// consisting of "**" matches any number of segments, so "IFD/GPSInfo/**" matches every tag in the
+// GPS IFD and all of its children.
+type pathMatcher struct {
+	exact    map[string]struct{}
+	patterns [][]string
+}
+
+func newPathMatcher(paths []string) pathMatcher {
+	matcher := pathMatcher{
+		exact:    make(map[string]struct{}),
+		patterns: [][]string{},
+	}
+
+	for _, p := range paths {
+		if !isPattern(p) {
+			matcher.exact[p] = struct{}{}
+			continue
+		}
+
+		matcher.patterns = append(matcher.patterns, strings.Split(p, pathSeparator))
+	}
+
+	return matcher
+}
+
+func (matcher *pathMatcher) matches(tagPath string) bool {
+	if _, found := matcher.exact[tagPath]; found {
+		return true
+	}
+
+	if len(matcher.patterns) == 0 {
+		return false
+	}
+
+	segments := strings.Split(tagPath, pathSeparator)
+	for _, pattern := range matcher.patterns {
+		if matchSegments(pattern, segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == recursiveWildcard {
+			// Try to match the rest of the pattern against every possible remainder
+			for skipped := 0; skipped <= len(segments); skipped++ {
+				if matchSegments(pattern[1:], segments[skipped:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		// Patterns have been validated beforehand, so we can ignore the error here
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+func isPattern(p string) bool {
+	return strings.ContainsAny(p, "*?[\\")
+}
+
+// Checks whether all given tag paths are valid patterns
+func ValidatePaths(paths []string) error {
+	for _, p := range paths {
+		for _, segment := range strings.Split(p, pathSeparator) {
+			if segment == recursiveWildcard {
+				continue
+			}
+
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid EXIF tag path pattern \"%s\": %w", p, err)
+			}
+		}
+	}
+
+	return nil
+}
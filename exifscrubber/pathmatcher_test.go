@@ -0,0 +1,49 @@
+package exifscrubber
+
+import (
+	"testing"
+)
+
+func TestPathMatcher(t *testing.T) {
+	type tType struct {
+		path     string
+		expected bool
+	}
+
+	matcher := newPathMatcher([]string{
+		"IFD/Orientation",
+		"IFD/Exif/*",
+		"IFD/GPSInfo/**",
+		"IFD1/*Resolution",
+	})
+
+	tests := []tType{
+		{path: "IFD/Orientation", expected: true},
+		{path: "IFD/Make", expected: false},
+		{path: "IFD/Exif/Flash", expected: true},
+		{ // single wildcard does not descend into child IFDs
+			path:     "IFD/Exif/Iop/InteroperabilityIndex",
+			expected: false,
+		},
+		{path: "IFD/GPSInfo/GPSLatitude", expected: true},
+		{path: "IFD/GPSInfo", expected: true},
+		{path: "IFD1/XResolution", expected: true},
+		{path: "IFD1/ResolutionUnit", expected: false},
+	}
+
+	for _, test := range tests {
+		if matcher.matches(test.path) != test.expected {
+			t.Errorf("path %s: expected match to be %t", test.path, test.expected)
+		}
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	if err := ValidatePaths([]string{"IFD/Exif/*", "IFD/GPSInfo/**"}); err != nil {
+		t.Errorf("valid patterns rejected: %s", err)
+	}
+
+	if err := ValidatePaths([]string{"IFD/Exif/[a-"}); err == nil {
+		t.Errorf("invalid pattern accepted")
+	}
+}
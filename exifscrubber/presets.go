@@ -0,0 +1,47 @@
+package exifscrubber
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Named groups of tag paths that can be used in place of individual paths
+var presets = map[string][]string{
+	"gps": {
+		"IFD/GPSInfo/**",
+	},
+	"device-serials": {
+		"IFD/CameraSerialNumber",
+		"IFD/Exif/BodySerialNumber",
+		"IFD/Exif/LensSerialNumber",
+		"IFD/Exif/ImageUniqueID",
+	},
+	"owner": {
+		"IFD/Artist",
+		"IFD/Copyright",
+		"IFD/XPAuthor",
+		"IFD/Exif/CameraOwnerName",
+	},
+}
+
+// Replaces preset names in `paths` by the tag paths they stand for. Entries that look like tag
+// paths (i.e., contain a "/") are kept as-is.
+func ExpandPresets(paths []string) ([]string, error) {
+	expanded := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		if strings.Contains(path, "/") {
+			expanded = append(expanded, path)
+			continue
+		}
+
+		presetPaths, found := presets[path]
+		if !found {
+			return nil, fmt.Errorf("unknown EXIF preset: \"%s\"", path)
+		}
+
+		expanded = append(expanded, presetPaths...)
+	}
+
+	return expanded, nil
+}
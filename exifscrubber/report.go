@@ -0,0 +1,122 @@
+package exifscrubber
+
+import (
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// TagReport describes what happened to a single EXIF tag during scrubbing.
+type TagReport struct {
+	// Fully-qualified path of the IFD the tag lives in, e.g., "IFD/GPSInfo" or "IFD1"
+	IfdPath string `json:"ifdPath"`
+	TagId   uint16 `json:"tagId"`
+	TagName string `json:"tagName"`
+	Removed bool   `json:"removed"`
+	// Whether the tag was kept with a less precise value
+	Coarsened bool `json:"coarsened,omitempty"`
+	// Whether the tag is part of the GPS IFD
+	Gps bool `json:"gps"`
+	// Whether the tag identifies the device or the owner, see the "device-serials" and "owner"
+	// presets
+	Identifying bool `json:"identifying"`
+}
+
+// ScrubReport lists every tag that was encountered while scrubbing a file, whether it was removed
+// or kept.
+type ScrubReport struct {
+	Tags []TagReport `json:"tags"`
+}
+
+func newScrubReport() *ScrubReport {
+	return &ScrubReport{
+		Tags: []TagReport{},
+	}
+}
+
+func (report *ScrubReport) add(ifdIdentity *exifcommon.IfdIdentity, tagId uint16, tagName string,
+	removed bool,
+) {
+	report.Tags = append(report.Tags, TagReport{
+		IfdPath:     ifdIdentity.String(),
+		TagId:       tagId,
+		TagName:     tagName,
+		Removed:     removed,
+		Gps:         isGpsIfd(ifdIdentity),
+		Identifying: isIdentifyingTag(ifdIdentity, tagName),
+	})
+}
+
+func (report *ScrubReport) addCoarsened(ifdIdentity *exifcommon.IfdIdentity, tagId uint16,
+	tagName string,
+) {
+	report.add(ifdIdentity, tagId, tagName, false)
+	report.Tags[len(report.Tags)-1].Coarsened = true
+}
+
+// Marks all tags in the IFD chain starting at `rootIfd` and in all child IFDs as removed
+func (report *ScrubReport) addRemovedChain(rootIfd *exif.Ifd) {
+	for ifd := rootIfd; ifd != nil; ifd = ifd.NextIfd() {
+		for _, ite := range ifd.Entries() {
+			if ite.ChildIfdPath() == "" {
+				report.add(ifd.IfdIdentity(), ite.TagId(), ite.TagName(), true)
+			}
+		}
+
+		for _, childIfd := range ifd.Children() {
+			report.addRemovedChain(childIfd)
+		}
+	}
+}
+
+// Returns the number of removed tags
+func (report *ScrubReport) RemovedCount() int {
+	count := 0
+	for _, tag := range report.Tags {
+		if tag.Removed {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Returns the number of kept tags
+func (report *ScrubReport) KeptCount() int {
+	return len(report.Tags) - report.RemovedCount()
+}
+
+// Returns whether any GPS tag survived scrubbing
+func (report *ScrubReport) KeptGps() bool {
+	for _, tag := range report.Tags {
+		if tag.Gps && !tag.Removed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns whether any tag identifying the device or its owner survived scrubbing
+func (report *ScrubReport) KeptIdentifying() bool {
+	for _, tag := range report.Tags {
+		if tag.Identifying && !tag.Removed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isGpsIfd(ifdIdentity *exifcommon.IfdIdentity) bool {
+	return ifdIdentity.TagId() == exifcommon.IfdGpsInfoStandardIfdIdentity.TagId()
+}
+
+// Tags that identify the device or its owner. Matched against unindexed paths so that such tags
+// are recognized in the thumbnail IFD as well.
+var identifyingTagMatcher = newPathMatcher(
+	append(append([]string{}, presets["device-serials"]...), presets["owner"]...),
+)
+
+func isIdentifyingTag(ifdIdentity *exifcommon.IfdIdentity, tagName string) bool {
+	return identifyingTagMatcher.matches(ifdIdentity.UnindexedString() + pathSeparator + tagName)
+}
@@ -0,0 +1,230 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var ErrMalformedImage = errors.New("malformed image structure")
+
+// JPEG markers relevant for stripping
+const (
+	jpegMarkerPrefix = 0xff
+	jpegMarkerSoi    = 0xd8
+	jpegMarkerEoi    = 0xd9
+	jpegMarkerSos    = 0xda
+	jpegMarkerApp0   = 0xe0
+	jpegMarkerApp2   = 0xe2
+	jpegMarkerApp14  = 0xee
+	jpegMarkerApp15  = 0xef
+	jpegMarkerCom    = 0xfe
+	jpegMarkerRst0   = 0xd0
+	jpegMarkerSof0   = 0xc0
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+const (
+	pngChunkIhdr = "IHDR"
+	pngChunkIend = "IEND"
+	pngChunkIccp = "iCCP"
+)
+
+// Ancillary PNG chunks that are kept since they affect how the image is rendered
+var keptPngAncillaryChunks = map[string]struct{}{
+	"tRNS": {},
+	"gAMA": {},
+	"cHRM": {},
+	"sRGB": {},
+	"iCCP": {},
+	"sBIT": {},
+	"cICP": {},
+	// Animation chunks (APNG)
+	"acTL": {},
+	"fcTL": {},
+	"fdAT": {},
+}
+
+// Removes all metadata from JPEG and PNG files without interpreting it. In contrast to ScrubExif(),
+// this only relies on the basic container structure, so it also works for files with malformed
+// metadata. For JPEG files, all APPn and comment segments are removed except for JFIF, ICC profile
+// and Adobe segments. For PNG files, all ancillary chunks are removed except for the ones that
+// affect how the image is rendered, e.g., transparency and color information.
+func (scrubber *ExifScrubber) StripMetadata(fileData []byte) ([]byte, error) {
+	if bytes.HasPrefix(fileData, []byte{jpegMarkerPrefix, jpegMarkerSoi}) {
+		return filterJpegSegments(fileData, func(marker byte, segment []byte) bool {
+			return isKeptJpegSegment(marker, segment) &&
+				!(scrubber.stripIccProfile && isIccJpegSegment(marker, segment))
+		})
+	}
+
+	if bytes.HasPrefix(fileData, pngSignature) {
+		return filterPngChunks(fileData, func(chunkType string, chunk []byte) bool {
+			return isKeptPngChunk(chunkType) &&
+				!(scrubber.stripIccProfile && chunkType == pngChunkIccp)
+		})
+	}
+
+	return nil, ErrUnknownFileType
+}
+
+// Calls `visit` with the marker and the raw bytes of every segment preceding the first scan.
+// Returns the offset at which the first scan starts.
+func forEachJpegSegment(fileData []byte, visit func(marker byte, segment []byte)) (int, error) {
+	if !bytes.HasPrefix(fileData, []byte{jpegMarkerPrefix, jpegMarkerSoi}) {
+		return 0, ErrMalformedImage
+	}
+
+	pos := 2
+	for {
+		if pos+2 > len(fileData) || fileData[pos] != jpegMarkerPrefix {
+			return 0, ErrMalformedImage
+		}
+
+		marker := fileData[pos+1]
+		switch {
+		case marker == jpegMarkerPrefix:
+			// Fill byte
+			pos++
+			continue
+		case marker == jpegMarkerSos || marker == jpegMarkerEoi:
+			return pos, nil
+		case marker < jpegMarkerSof0 || (marker >= jpegMarkerRst0 && marker <= jpegMarkerSoi):
+			// Markers below SOF0 are reserved or only occur within scans, as do RST markers. SOI
+			// must not be repeated.
+			return 0, ErrMalformedImage
+		}
+
+		if pos+4 > len(fileData) {
+			return 0, ErrMalformedImage
+		}
+
+		segmentEnd := pos + 2 + int(binary.BigEndian.Uint16(fileData[pos+2:]))
+		if segmentEnd > len(fileData) || segmentEnd < pos+4 {
+			return 0, ErrMalformedImage
+		}
+
+		visit(marker, fileData[pos:segmentEnd])
+		pos = segmentEnd
+	}
+}
+
+// Returns `fileData` without the segments preceding the first scan for which `keep` returns false.
+// Entropy-coded data and everything following it is copied as-is since it contains no metadata.
+func filterJpegSegments(fileData []byte, keep func(marker byte, segment []byte) bool) ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.Write(fileData[:2])
+
+	scanStart, err := forEachJpegSegment(fileData, func(marker byte, segment []byte) {
+		if keep(marker, segment) {
+			b.Write(segment)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.Write(fileData[scanStart:])
+	return b.Bytes(), nil
+}
+
+// Returns the payload of a segment, i.e., the bytes following the marker and length
+func jpegSegmentPayload(segment []byte) []byte {
+	if len(segment) < 4 {
+		return nil
+	}
+
+	return segment[4:]
+}
+
+func isKeptJpegSegment(marker byte, segment []byte) bool {
+	payload := jpegSegmentPayload(segment)
+
+	switch {
+	case marker == jpegMarkerApp0:
+		// JFIF extension (JFXX) segments are removed since they hold thumbnails
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == jpegMarkerApp2:
+		return isIccJpegSegment(marker, segment)
+	case marker == jpegMarkerApp14:
+		// Holds the color transform required to decode Adobe CMYK and YCCK images
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= jpegMarkerApp0 && marker <= jpegMarkerApp15, marker == jpegMarkerCom:
+		return false
+	default:
+		return true
+	}
+}
+
+// Unlike PngMediaParser.LooksLikeFormat(), this does not panic on data shorter than the signature
+func looksLikePng(fileData []byte) bool {
+	return bytes.HasPrefix(fileData, pngSignature)
+}
+
+// Calls `visit` with the type and the raw bytes of every chunk up to and including IEND
+func forEachPngChunk(fileData []byte, visit func(chunkType string, chunk []byte)) error {
+	if !bytes.HasPrefix(fileData, pngSignature) {
+		return ErrMalformedImage
+	}
+
+	pos := len(pngSignature)
+	for pos < len(fileData) {
+		// Chunk layout: length (4 bytes), type (4 bytes), data, CRC (4 bytes)
+		if pos+8 > len(fileData) {
+			return ErrMalformedImage
+		}
+
+		chunkLength := int(binary.BigEndian.Uint32(fileData[pos:]))
+		chunkEnd := pos + 12 + chunkLength
+		if chunkLength < 0 || chunkEnd > len(fileData) || chunkEnd < pos {
+			return ErrMalformedImage
+		}
+
+		// The CRC covers type and data. Passing on a chunk with a broken CRC would produce a file
+		// that other tools, including our own verification, refuse to read.
+		crc := binary.BigEndian.Uint32(fileData[chunkEnd-4:])
+		if crc32.ChecksumIEEE(fileData[pos+4:chunkEnd-4]) != crc {
+			return ErrMalformedImage
+		}
+
+		chunkType := string(fileData[pos+4 : pos+8])
+		visit(chunkType, fileData[pos:chunkEnd])
+
+		pos = chunkEnd
+		if chunkType == pngChunkIend {
+			return nil
+		}
+	}
+
+	// No IEND chunk
+	return ErrMalformedImage
+}
+
+// Returns `fileData` without the chunks for which `keep` returns false
+func filterPngChunks(fileData []byte, keep func(chunkType string, chunk []byte) bool) ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.Write(pngSignature)
+
+	err := forEachPngChunk(fileData, func(chunkType string, chunk []byte) {
+		if keep(chunkType, chunk) {
+			b.Write(chunk)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func isKeptPngChunk(chunkType string) bool {
+	// Critical chunks start with an uppercase letter
+	if chunkType[0] >= 'A' && chunkType[0] <= 'Z' {
+		return true
+	}
+
+	_, kept := keptPngAncillaryChunks[chunkType]
+	return kept
+}
@@ -0,0 +1,168 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	jis "github.com/dsoprea/go-jpeg-image-structure/v2"
+	exiflog "github.com/dsoprea/go-logging"
+	pis "github.com/dsoprea/go-png-image-structure/v2"
+)
+
+// Asserts that both images decode to the same pixels
+func assertSamePixels(t *testing.T, have []byte, want []byte) {
+	haveImg, _, err := image.Decode(bytes.NewReader(have))
+	if err != nil {
+		t.Fatalf("could not decode image: %s", err)
+	}
+
+	wantImg, _, err := image.Decode(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("could not decode image: %s", err)
+	}
+
+	if haveImg.Bounds() != wantImg.Bounds() {
+		t.Fatalf("bounds differ: have %v, want %v", haveImg.Bounds(), wantImg.Bounds())
+	}
+
+	bounds := wantImg.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if haveImg.At(x, y) != wantImg.At(x, y) {
+				t.Fatalf("pixels differ at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestStripJpegFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	stripped, err := scrubber.StripMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, segment := range intfc.(*jis.SegmentList).Segments() {
+		if segment.MarkerId == jis.MARKER_APP1 || segment.MarkerId == jis.MARKER_COM {
+			t.Errorf("segment %s still present", segment.MarkerName)
+		}
+	}
+
+	assertSamePixels(t, stripped, buf)
+}
+
+func TestStripJfifThumbnail(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/jfxx-thumbnail.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	stripped, err := scrubber.StripMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(stripped, []byte("JFIF\x00")) {
+		t.Errorf("JFIF segment removed")
+	}
+
+	if bytes.Contains(stripped, []byte("JFXX\x00")) {
+		t.Errorf("JFXX segment with thumbnail still present")
+	}
+
+	assertSamePixels(t, stripped, buf)
+}
+
+func TestStripPngFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.png")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	stripped, err := scrubber.StripMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intfc, err := pis.NewPngMediaParser().ParseBytes(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range intfc.(*pis.ChunkSlice).Chunks() {
+		switch chunk.Type {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			t.Errorf("chunk %s still present", chunk.Type)
+		}
+	}
+
+	assertSamePixels(t, stripped, buf)
+}
+
+func TestStripMalformedExif(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Point the first IFD of the EXIF segment beyond the end of the segment
+	tiffHeaderIdx := bytes.Index(buf, []byte("Exif\x00\x00")) + 6
+	corrupted := append([]byte{}, buf...)
+	copy(corrupted[tiffHeaderIdx+4:], []byte{0x00, 0xff, 0xff, 0x00})
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	_, _, err = scrubber.ScrubExif(corrupted)
+	if err == nil {
+		t.Fatalf("expected scrubbing to fail on malformed EXIF")
+	}
+
+	stripped, err := scrubber.StripMetadata(corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intfc, err := jis.NewJpegMediaParser().ParseBytes(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = intfc.(*jis.SegmentList).Exif()
+	if !exiflog.Is(err, exif.ErrNoExif) {
+		t.Errorf("EXIF still present after stripping: %v", err)
+	}
+
+	assertSamePixels(t, stripped, buf)
+}
+
+func TestStripTruncatedFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.png")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+	_, err = scrubber.StripMetadata(buf[:len(buf)/2])
+	if err != ErrMalformedImage {
+		t.Errorf("have error %v, want %v", err, ErrMalformedImage)
+	}
+
+	_, err = scrubber.StripMetadata([]byte("not an image"))
+	if err != ErrUnknownFileType {
+		t.Errorf("have error %v, want %v", err, ErrUnknownFileType)
+	}
+}
@@ -0,0 +1,157 @@
+package exifscrubber
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+const (
+	makerNoteTagId = 0x927c
+
+	// Size of the tag count at the start of an IFD
+	ifdCountSize = 2
+	// Size of a single tag entry in an IFD
+	ifdEntrySize = 12
+	// Values up to this size are stored in the tag entry itself instead of at an offset
+	ifdInlineValueSize = 4
+
+	// Type of pointers to sub-IFDs introduced by TIFF 6.0 technical note 1, unknown to the EXIF
+	// library
+	tagTypeIfd exifcommon.TagTypePrimitive = 13
+)
+
+// A tag the EXIF parser silently skipped because it does not know it or because its type does not
+// match the standard, e.g., a private vendor tag or a pointer to a non-standard IFD
+type unparsedTag struct {
+	tagId uint16
+	// Empty if the tag is not part of the standard
+	tagName string
+	tagType exifcommon.TagTypePrimitive
+	count   uint32
+	// Offset of the value or of the offset of the value in the raw EXIF data
+	valueOffset int
+}
+
+// Reads the tag entries of `ifd` directly from `rawExif` and returns all tags that are missing from
+// the parsed IFD
+func unparsedTagsOf(ifd *exif.Ifd, rawExif []byte, tagIndex *exif.TagIndex) []unparsedTag {
+	offset := int(ifd.Offset())
+	if offset < 0 || offset+ifdCountSize > len(rawExif) {
+		return nil
+	}
+
+	byteOrder := ifd.ByteOrder()
+	parsed := ifd.EntriesByTagId()
+
+	tags := []unparsedTag{}
+	tagCount := int(byteOrder.Uint16(rawExif[offset:]))
+	for i := 0; i < tagCount; i++ {
+		entryOffset := offset + ifdCountSize + i*ifdEntrySize
+		if entryOffset+ifdEntrySize > len(rawExif) {
+			// Truncated IFD, the parser will have failed on this as well
+			break
+		}
+
+		tagId := byteOrder.Uint16(rawExif[entryOffset:])
+		if _, found := parsed[tagId]; found {
+			continue
+		}
+
+		tagName := ""
+		if it, err := tagIndex.Get(ifd.IfdIdentity(), tagId); err == nil {
+			tagName = it.Name
+		}
+
+		tags = append(tags, unparsedTag{
+			tagId:       tagId,
+			tagName:     tagName,
+			tagType:     exifcommon.TagTypePrimitive(byteOrder.Uint16(rawExif[entryOffset+2:])),
+			count:       byteOrder.Uint32(rawExif[entryOffset+4:]),
+			valueOffset: entryOffset + 8,
+		})
+	}
+
+	return tags
+}
+
+// Reports the tags of `ifd` the parser skipped and returns the ones that are kept, see
+// isUnparsedTagAllowed()
+func (scrubber *ExifScrubber) keptUnparsedTags(
+	ifd *exif.Ifd,
+	rawExif []byte,
+	tagIndex *exif.TagIndex,
+	report *ScrubReport,
+) []unparsedTag {
+	kept := []unparsedTag{}
+	for _, tag := range unparsedTagsOf(ifd, rawExif, tagIndex) {
+		isAllowed := scrubber.isUnparsedTagAllowed(ifd.IfdIdentity(), tag)
+		report.add(ifd.IfdIdentity(), tag.tagId, tag.tagName, !isAllowed)
+		if isAllowed {
+			kept = append(kept, tag)
+		}
+	}
+
+	return kept
+}
+
+// Adds the tag `tag` of `ifd` to `ib` with its unmodified value
+func addUnparsedTag(ib *exif.IfdBuilder, ifd *exif.Ifd, tag unparsedTag, rawExif []byte) error {
+	rawValue, err := tag.rawValue(rawExif, ifd.ByteOrder())
+	if err != nil {
+		return err
+	}
+
+	bt := exif.NewBuilderTag(
+		ifd.IfdIdentity().UnindexedString(),
+		tag.tagId,
+		tag.tagType,
+		exif.NewIfdBuilderTagValueFromBytes(rawValue),
+		ifd.ByteOrder(),
+	)
+
+	return ib.Add(bt)
+}
+
+// Whether the value of the tag can be copied without interpreting it. The EXIF library cannot
+// write types it does not know, e.g., signed bytes.
+func (tag unparsedTag) isCopyable() bool {
+	return tag.tagType.IsValid() && tag.tagType != exifcommon.TypeAsciiNoNul
+}
+
+// Whether the value of the tag may be an offset into the EXIF data, e.g., of a non-standard IFD.
+// Copying such a value would leave a pointer to whatever ends up at that offset in the rewritten
+// EXIF data.
+func (tag unparsedTag) mayBeOffset() bool {
+	return tag.count == 1 && (tag.tagType == exifcommon.TypeLong || tag.tagType == tagTypeIfd)
+}
+
+// Returns the raw bytes of the value of the tag
+func (tag unparsedTag) rawValue(rawExif []byte, byteOrder binary.ByteOrder) ([]byte, error) {
+	// Values of undefined type are written as bytes
+	unitSize := 1
+	if tag.tagType != exifcommon.TypeUndefined {
+		unitSize = tag.tagType.Size()
+	}
+
+	size := uint64(tag.count) * uint64(unitSize)
+	if size <= ifdInlineValueSize {
+		return rawExif[tag.valueOffset : tag.valueOffset+int(size)], nil
+	}
+
+	offset := uint64(byteOrder.Uint32(rawExif[tag.valueOffset:]))
+	if offset+size > uint64(len(rawExif)) {
+		return nil, fmt.Errorf(
+			"%w: unparsed tag 0x%04x claims %d bytes at offset %d but the EXIF data only has %d",
+			ErrMalformedExif,
+			tag.tagId,
+			size,
+			offset,
+			len(rawExif),
+		)
+	}
+
+	return rawExif[offset : offset+size], nil
+}
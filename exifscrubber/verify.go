@@ -0,0 +1,233 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	jis "github.com/dsoprea/go-jpeg-image-structure/v2"
+	exiflog "github.com/dsoprea/go-logging"
+	pis "github.com/dsoprea/go-png-image-structure/v2"
+)
+
+var ErrVerificationFailed = errors.New("scrubbed file still contains disallowed EXIF")
+
+// Identifies APP1 segments holding EXIF, regardless of whether the EXIF data itself is valid
+const exifJpegIdentifier = "Exif\x00\x00"
+
+// Re-parses scrubbed file data and checks that it does not contain any EXIF that would have been
+// removed by ScrubExif(). Returns an error wrapping ErrVerificationFailed if disallowed EXIF is
+// found or if EXIF is present but cannot be parsed. Files of unknown type pass verification.
+func (scrubber *ExifScrubber) Verify(fileData []byte) error {
+	err := scrubber.verify(fileData)
+	if errors.Is(err, ErrUnknownFileType) {
+		return nil
+	}
+
+	return err
+}
+
+// Like Verify() but returns ErrUnknownFileType for files of unknown type
+func (scrubber *ExifScrubber) verify(fileData []byte) error {
+	rootIfd, rawExif, err := parseExif(fileData)
+	if err != nil {
+		if errors.Is(err, ErrUnknownFileType) {
+			return err
+		}
+
+		return fmt.Errorf("%w: %s", ErrVerificationFailed, err)
+	}
+
+	if rootIfd == nil {
+		// No EXIF at all
+		return nil
+	}
+
+	if scrubber.bakeOrientation {
+		return fmt.Errorf("%w: EXIF present although all EXIF should have been removed",
+			ErrVerificationFailed)
+	}
+
+	tagIndex := exif.NewTagIndex()
+	err = exif.LoadStandardTags(tagIndex)
+	if err != nil {
+		return err
+	}
+
+	return scrubber.verifyChain(rootIfd, rawExif, tagIndex)
+}
+
+// Parses the EXIF contained in JPEG and PNG files. Returns a nil IFD if the file contains no EXIF
+// and an error if it contains EXIF that cannot be parsed. Files with more than one EXIF segment or
+// chunk are rejected as well, since only the first one is parsed.
+func parseExif(fileData []byte) (*exif.Ifd, []byte, error) {
+	var rootIfd *exif.Ifd
+	var rawExif []byte
+	var exifCount int
+	var exifErr error
+
+	jpegParser := jis.NewJpegMediaParser()
+	pngParser := pis.NewPngMediaParser()
+	if jpegParser.LooksLikeFormat(fileData) {
+		intfc, err := jpegParser.ParseBytes(fileData)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		segmentList := intfc.(*jis.SegmentList)
+		for _, segment := range segmentList.Segments() {
+			if segment.MarkerId == jis.MARKER_APP1 &&
+				bytes.HasPrefix(segment.Data, []byte(exifJpegIdentifier)) {
+				exifCount++
+			}
+		}
+
+		rootIfd, rawExif, exifErr = segmentList.Exif()
+	} else if looksLikePng(fileData) {
+		intfc, err := pngParser.ParseBytes(fileData)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		chunks := intfc.(*pis.ChunkSlice)
+		exifCount = len(chunks.Index()[pis.EXifChunkType])
+
+		rootIfd, rawExif, exifErr = chunks.Exif()
+	} else {
+		return nil, nil, ErrUnknownFileType
+	}
+
+	if exifCount > 1 {
+		return nil, nil, fmt.Errorf("%d EXIF segments present", exifCount)
+	}
+
+	if exifErr != nil {
+		if !exiflog.Is(exifErr, exif.ErrNoExif) {
+			return nil, nil, exifErr
+		}
+
+		if exifCount > 0 {
+			// The parser did not recognize the EXIF data, but other parsers might
+			return nil, nil, errors.New("EXIF present but not parseable")
+		}
+
+		return nil, nil, nil
+	}
+
+	return rootIfd, rawExif, nil
+}
+
+func (scrubber *ExifScrubber) verifyChain(
+	rootIfd *exif.Ifd,
+	rawExif []byte,
+	tagIndex *exif.TagIndex,
+) error {
+	for ifd := rootIfd; ifd != nil; ifd = ifd.NextIfd() {
+		if !scrubber.keepThumbnail && isThumbnailIfd(ifd.IfdIdentity()) {
+			return fmt.Errorf("%w: thumbnail IFD %s present", ErrVerificationFailed,
+				ifd.IfdIdentity())
+		}
+
+		err := scrubber.verifyIfd(ifd, rawExif, tagIndex)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (scrubber *ExifScrubber) verifyIfd(ifd *exif.Ifd, rawExif []byte, tagIndex *exif.TagIndex) error {
+	unparsedTags := unparsedTagsOf(ifd, rawExif, tagIndex)
+	if len(ifd.Entries()) == 0 && len(unparsedTags) == 0 && isGpsIfd(ifd.IfdIdentity()) {
+		return fmt.Errorf("%w: empty GPS IFD present", ErrVerificationFailed)
+	}
+
+	// Tags the parser skipped are only passed through where scrubbing keeps them as well
+	for _, tag := range unparsedTags {
+		if !scrubber.isUnparsedTagAllowed(ifd.IfdIdentity(), tag) {
+			return fmt.Errorf("%w: unparseable tag 0x%04x in %s", ErrVerificationFailed,
+				tag.tagId, ifd.IfdIdentity())
+		}
+	}
+
+	for _, ite := range ifd.Entries() {
+		if ite.ChildIfdPath() != "" || ite.IsThumbnailOffset() || ite.IsThumbnailSize() {
+			continue
+		}
+
+		if !scrubber.isTagAllowedInOutput(ifd.IfdIdentity(), ite) {
+			return fmt.Errorf("%w: tag %s/%s (0x%04x)", ErrVerificationFailed,
+				ifd.IfdIdentity(), ite.TagName(), ite.TagId())
+		}
+
+		if scrubber.isCoarsenedTag(ifd.IfdIdentity(), ite) {
+			err := scrubber.verifyCoarsened(ite, rawExif)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, childIfd := range ifd.Children() {
+		err := scrubber.verifyChain(childIfd, rawExif, tagIndex)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Whether the tag may be part of scrubbed output. Mirrors the decisions made while filtering.
+func (scrubber *ExifScrubber) isTagAllowedInOutput(
+	ifdIdentity *exifcommon.IfdIdentity,
+	ite *exif.IfdTagEntry,
+) bool {
+	if ite.TagId() == makerNoteTagId {
+		return false
+	}
+
+	if scrubber.coarsenGps && isGpsIfd(ifdIdentity) {
+		if _, precise := preciseGpsTagIds[ite.TagId()]; precise {
+			return false
+		}
+	}
+
+	return scrubber.isTagAllowed(ite)
+}
+
+func (scrubber *ExifScrubber) isCoarsenedTag(
+	ifdIdentity *exifcommon.IfdIdentity,
+	ite *exif.IfdTagEntry,
+) bool {
+	return scrubber.coarsenGps && isGpsIfd(ifdIdentity) &&
+		(ite.TagId() == gpsLatitudeTagId || ite.TagId() == gpsLongitudeTagId)
+}
+
+// Checks that a coordinate is not more precise than configured
+func (scrubber *ExifScrubber) verifyCoarsened(ite *exif.IfdTagEntry, rawExif []byte) error {
+	value, err := tagValue(ite, rawExif)
+	if err != nil {
+		return err
+	}
+
+	dms, ok := value.([]exifcommon.Rational)
+	if !ok || len(dms) != 3 {
+		return fmt.Errorf("%w: %s", ErrVerificationFailed, ErrInvalidGpsCoordinate)
+	}
+
+	maxDenominator := uint32(1)
+	for i := 0; i < scrubber.gpsDecimals; i++ {
+		maxDenominator *= 10
+	}
+
+	if dms[0].Denominator > maxDenominator || dms[1].Numerator != 0 || dms[2].Numerator != 0 {
+		return fmt.Errorf("%w: tag %s is more precise than %d decimal places",
+			ErrVerificationFailed, ite.TagName(), scrubber.gpsDecimals)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package exifscrubber
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerifyFromFile(t *testing.T) {
+	for _, fixture := range []string{"gps.jpg", "gps.png"} {
+		buf, err := ioutil.ReadFile("../fixtures/" + fixture)
+		if err != nil {
+			t.Fatalf("could not open file %s", fixture)
+		}
+
+		scrubber := NewExifScrubber([]uint16{}, []string{"IFD/Orientation"}, Options{})
+
+		// The original contains GPS data and lots of other tags
+		err = scrubber.Verify(buf)
+		if !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("%s: have error %v, want %v", fixture, err, ErrVerificationFailed)
+		}
+
+		updatedBuf, _, err := scrubber.ScrubExif(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = scrubber.Verify(updatedBuf)
+		if err != nil {
+			t.Errorf("%s: verification of scrubbed file failed: %s", fixture, err)
+		}
+	}
+}
+
+func TestVerifyCoarsenedFromFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{"IFD/GPSInfo/**"}, Options{})
+	scrubber.EnableGpsCoarsening(1)
+
+	// Even though all GPS tags are allowed, exact coordinates must not pass
+	err = scrubber.Verify(buf)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("have error %v, want %v", err, ErrVerificationFailed)
+	}
+
+	updatedBuf, _, err := scrubber.ScrubExif(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = scrubber.Verify(updatedBuf)
+	if err != nil {
+		t.Errorf("verification of scrubbed file failed: %s", err)
+	}
+}
+
+func TestVerifyUnparseableExif(t *testing.T) {
+	buf, err := ioutil.ReadFile("../fixtures/gps.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	// Corrupt the byte order of the TIFF header. The parser then doesn't recognize the segment as
+	// EXIF, but the GPS data is still in there.
+	tiffHeaderIdx := bytes.Index(buf, []byte(exifJpegIdentifier)) + len(exifJpegIdentifier)
+	corrupted := append([]byte{}, buf...)
+	copy(corrupted[tiffHeaderIdx:], "XX")
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+	_, _, err = scrubber.ScrubExif(corrupted)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("have error %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+func TestVerifyDuplicateExif(t *testing.T) {
+	// Holds the same EXIF segment twice. Only the first one is parsed, so the second one would be
+	// passed through with its GPS data.
+	buf, err := ioutil.ReadFile("../fixtures/duplicate-exif.jpg")
+	if err != nil {
+		t.Fatalf("could not open file")
+	}
+
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+	_, _, err = scrubber.ScrubExif(buf)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("have error %v, want %v", err, ErrVerificationFailed)
+	}
+
+	strippedBuf, err := scrubber.StripMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = scrubber.Verify(strippedBuf)
+	if err != nil {
+		t.Errorf("verification of stripped file failed: %s", err)
+	}
+}
+
+func TestVerifyUnknownFileType(t *testing.T) {
+	scrubber := NewExifScrubber([]uint16{}, []string{}, Options{})
+
+	err := scrubber.Verify([]byte("just some text"))
+	if err != nil {
+		t.Errorf("have error %v, want nil", err)
+	}
+}
@@ -11,7 +11,13 @@ var knownCombinations []string = []string{
 	".tar.xz",
 }
 
+// Path separators of all platforms clients may upload from. Everything up to the last separator
+// is ignored so that extensions can never contain one.
+const pathSeparators = "/\\"
+
 func BuildFileExtension(fileData []byte, name string) string {
+	name = name[strings.LastIndexAny(name, pathSeparators)+1:]
+
 	// First, check whether any file ending has been specified manually
 	curExtIdx := strings.LastIndex(name, ".")
 
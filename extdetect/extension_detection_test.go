@@ -2,6 +2,7 @@ package extdetect
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -54,6 +55,15 @@ func TestDetectedExtensions(t *testing.T) {
 			name:           "foo.jpg.zip.tar.gz",
 			expectedOutput: ".tar.gz",
 		},
+		{ // directories are ignored
+			name:           "dir.d/foo.txt",
+			expectedOutput: ".txt",
+		},
+		{ // "." in directories is not mistaken for an extension
+			name:           "C:\\dir.tar\\foo",
+			fileData:       pngFile,
+			expectedOutput: ".png",
+		},
 	}
 
 	for _, test := range tests {
@@ -63,3 +73,27 @@ func TestDetectedExtensions(t *testing.T) {
 		}
 	}
 }
+
+func FuzzBuildFileExtension(f *testing.F) {
+	pngFile, err := os.ReadFile("../fixtures/gps.png")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, name := range []string{"foo", "foo.txt", "foo.tar.gz", "foo.jpg.zip.tar.gz", "a.b/../c"} {
+		f.Add(pngFile, name)
+		f.Add([]byte{}, name)
+	}
+
+	f.Fuzz(func(t *testing.T, fileData []byte, name string) {
+		ext := BuildFileExtension(fileData, name)
+
+		if strings.ContainsAny(ext, pathSeparators) {
+			t.Errorf("extension %q for name %q contains a path separator", ext, name)
+		}
+
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			t.Errorf("extension %q for name %q does not start with \".\"", ext, name)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+//go:build ignore
+
+// Generates the maker note fixtures used by the exifscrubber tests. The images themselves are
+// synthetic: the EXIF data mimics the maker note layouts and private tags written by cameras of
+// several vendors. Run from the repository root with:
+//
+//	go run fixtures/generate_makernotes.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"os"
+)
+
+const (
+	typeByte      = 1
+	typeAscii     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+)
+
+type entry struct {
+	tagId    uint16
+	tagType  uint16
+	count    uint32
+	data     []byte
+	childIfd *ifd
+}
+
+type ifd struct {
+	entries []entry
+}
+
+func ascii(s string) entry {
+	return entry{tagType: typeAscii, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+func short(v uint16) entry {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return entry{tagType: typeShort, count: 1, data: b}
+}
+
+func long(v uint32) entry {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return entry{tagType: typeLong, count: 1, data: b}
+}
+
+func rationals(values ...uint32) entry {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return entry{tagType: typeRational, count: uint32(len(values) / 2), data: b}
+}
+
+func undefined(data []byte) entry {
+	return entry{tagType: typeUndefined, count: uint32(len(data)), data: data}
+}
+
+func with(tagId uint16, e entry) entry {
+	e.tagId = tagId
+	return e
+}
+
+func pointer(tagId uint16, child *ifd) entry {
+	return entry{tagId: tagId, tagType: typeLong, count: 1, childIfd: child}
+}
+
+// Serializes `root` as a little-endian TIFF structure. Child IFDs and values that don't fit into
+// an entry are appended after the IFD they belong to. `base` is the offset at which `out` starts
+// relative to the TIFF header.
+func (d *ifd) write(out *bytes.Buffer, base int) {
+	start := base + out.Len()
+	dataStart := start + 2 + 12*len(d.entries) + 4
+
+	var data bytes.Buffer
+	var children []*ifd
+	var childFixups []int
+
+	binary.Write(out, binary.LittleEndian, uint16(len(d.entries)))
+	for _, e := range d.entries {
+		binary.Write(out, binary.LittleEndian, e.tagId)
+		binary.Write(out, binary.LittleEndian, e.tagType)
+		binary.Write(out, binary.LittleEndian, e.count)
+
+		if e.childIfd != nil {
+			childFixups = append(childFixups, out.Len())
+			children = append(children, e.childIfd)
+			out.Write([]byte{0, 0, 0, 0})
+		} else if len(e.data) <= 4 {
+			value := make([]byte, 4)
+			copy(value, e.data)
+			out.Write(value)
+		} else {
+			binary.Write(out, binary.LittleEndian, uint32(dataStart+data.Len()))
+			data.Write(e.data)
+			if data.Len()%2 == 1 {
+				data.WriteByte(0)
+			}
+		}
+	}
+
+	// No next IFD
+	out.Write([]byte{0, 0, 0, 0})
+	out.Write(data.Bytes())
+
+	for i, child := range children {
+		childOffset := base + out.Len()
+		binary.LittleEndian.PutUint32(out.Bytes()[childFixups[i]:], uint32(childOffset))
+		child.write(out, base)
+	}
+}
+
+func tiff(root *ifd) []byte {
+	out := new(bytes.Buffer)
+	out.Write([]byte{'I', 'I', 0x2a, 0x00, 0x08, 0x00, 0x00, 0x00})
+	root.write(out, 0)
+	return out.Bytes()
+}
+
+// A maker note consisting of an optional header followed by an IFD whose value offsets are
+// relative to the TIFF header of the enclosing EXIF block, as written by many cameras. Since
+// offsets depend on the final position of the maker note, they are left pointing at arbitrary
+// locations, just like they do once a maker note has been moved by a naive EXIF editor.
+func makerNote(header []byte, entries int) []byte {
+	out := new(bytes.Buffer)
+	out.Write(header)
+	binary.Write(out, binary.LittleEndian, uint16(entries))
+	for i := 0; i < entries; i++ {
+		binary.Write(out, binary.LittleEndian, uint16(0x0001+i))
+		binary.Write(out, binary.LittleEndian, uint16(typeAscii))
+		binary.Write(out, binary.LittleEndian, uint32(32))
+		binary.Write(out, binary.LittleEndian, uint32(0x1000+0x40*i))
+	}
+	out.Write([]byte{0, 0, 0, 0})
+	out.Write([]byte("SERIAL-0123456789\x00OWNER-JANE-DOE\x00"))
+	return out.Bytes()
+}
+
+func gpsIfd() *ifd {
+	return &ifd{entries: []entry{
+		with(0x0000, entry{tagType: typeByte, count: 4, data: []byte{2, 3, 0, 0}}),
+		with(0x0001, ascii("N")),
+		with(0x0002, rationals(52, 1, 31, 1, 1234, 100)),
+		with(0x0003, ascii("E")),
+		with(0x0004, rationals(13, 1, 24, 1, 5678, 100)),
+	}}
+}
+
+func exifIfd(makerNoteData []byte, extra ...entry) *ifd {
+	entries := []entry{
+		with(0x829a, rationals(1, 125)),
+		with(0x9003, ascii("2023:06:04 12:00:00")),
+		with(0x927c, undefined(makerNoteData)),
+		with(0xa431, ascii("0123456789")),
+	}
+	return &ifd{entries: append(entries, extra...)}
+}
+
+func writeFixture(name string, root *ifd) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(16 * x), G: uint8(16 * y), B: 0x80, A: 0xff})
+		}
+	}
+
+	encoded := new(bytes.Buffer)
+	err := jpeg.Encode(encoded, img, &jpeg.Options{Quality: 90})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exifData := append([]byte("Exif\x00\x00"), tiff(root)...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(exifData)+2))
+
+	out := new(bytes.Buffer)
+	// Insert EXIF right after the SOI marker
+	out.Write(encoded.Bytes()[:2])
+	out.Write(app1)
+	out.Write(exifData)
+	out.Write(encoded.Bytes()[2:])
+
+	err = os.WriteFile(name, out.Bytes(), 0o644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	// Canon: the maker note is a plain IFD without a header
+	writeFixture("fixtures/makernote-canon.jpg", &ifd{entries: []entry{
+		with(0x010f, ascii("Canon")),
+		with(0x0110, ascii("Canon EOS Synthetic")),
+		with(0x0112, short(1)),
+		pointer(0x8769, exifIfd(makerNote(nil, 4))),
+		pointer(0x8825, gpsIfd()),
+	}})
+
+	// Nikon: the maker note starts with a "Nikon" header and an embedded TIFF header. The camera
+	// also writes a private tag unknown to the EXIF standard into IFD0.
+	writeFixture("fixtures/makernote-nikon.jpg", &ifd{entries: []entry{
+		with(0x010f, ascii("NIKON CORPORATION")),
+		with(0x0110, ascii("NIKON Synthetic")),
+		with(0x0112, short(1)),
+		pointer(0x8769, exifIfd(makerNote([]byte("Nikon\x00\x02\x10\x00\x00II*\x00\x08\x00\x00\x00"), 3))),
+		pointer(0x8825, gpsIfd()),
+		with(0xfde8, ascii("Nikon private data")),
+	}})
+
+	// Sony: the maker note starts with a "SONY DSC" header. The camera stores a private sub-IFD
+	// that is not part of the standard IFD mapping, as well as a standard tag with a non-standard
+	// type.
+	writeFixture("fixtures/makernote-sony.jpg", &ifd{entries: []entry{
+		with(0x010f, ascii("SONY")),
+		with(0x0110, ascii("SONY Synthetic")),
+		with(0x0112, long(1)),
+		pointer(0x8769, exifIfd(makerNote([]byte("SONY DSC \x00\x00\x00"), 5))),
+		pointer(0x8825, gpsIfd()),
+		pointer(0xc634, &ifd{entries: []entry{
+			with(0x0001, ascii("private")),
+			with(0x0002, long(42)),
+		}}),
+	}})
+}
@@ -3,6 +3,7 @@ module github.com/leon-richardt/jaf
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/dsoprea/go-exif/v3 v3.0.0-20210512043655-120bcdb2a55e
 	github.com/dsoprea/go-jpeg-image-structure/v2 v2.0.0-20210512043942-b434301c6836
 	github.com/dsoprea/go-logging v0.0.0-20200710184922-b02d349568dd
@@ -10,6 +11,7 @@ require (
 	github.com/gabriel-vasile/mimetype v1.4.1
 	github.com/go-errors/errors v1.1.1
 	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 require (
@@ -19,5 +21,4 @@ require (
 	github.com/go-xmlfmt/xmlfmt v0.0.0-20191208150333-d5b6f63a941b // indirect
 	github.com/golang/geo v0.0.0-20200319012246-673a6f80352d // indirect
 	golang.org/x/net v0.0.0-20220624214902-1bab6f366d9e // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+// Path of the inspection endpoint. Files sent to "/inspect/<name>" are inspected with the scrubber
+// of the profile named <name>.
+const inspectRoute = "/inspect"
+
+// Reports the metadata of an uploaded file and what scrubbing would remove, without storing the
+// file
+type inspectHandler struct {
+	live *reloadableConfig
+}
+
+// Response body of the inspection endpoint
+type inspectResponse struct {
+	// Tags grouped by the fully-qualified path of their IFD, e.g., "IFD/GPSInfo"
+	Ifds         map[string][]exifscrubber.TagReport `json:"ifds"`
+	RemovedCount int                                 `json:"removedCount"`
+	KeptCount    int                                 `json:"keptCount"`
+	// Whether the file contains any GPS tags, regardless of whether they would be removed
+	HasGps bool `json:"hasGps"`
+	// Whether the file contains any tags identifying the device or its owner
+	HasIdentifying  bool `json:"hasIdentifying"`
+	KeptGps         bool `json:"keptGps"`
+	KeptIdentifying bool `json:"keptIdentifying"`
+}
+
+func newInspectResponse(report *exifscrubber.ScrubReport) inspectResponse {
+	response := inspectResponse{
+		Ifds:            make(map[string][]exifscrubber.TagReport),
+		RemovedCount:    report.RemovedCount(),
+		KeptCount:       report.KeptCount(),
+		KeptGps:         report.KeptGps(),
+		KeptIdentifying: report.KeptIdentifying(),
+	}
+
+	for _, tag := range report.Tags {
+		response.Ifds[tag.IfdPath] = append(response.Ifds[tag.IfdPath], tag)
+		response.HasGps = response.HasGps || tag.Gps
+		response.HasIdentifying = response.HasIdentifying || tag.Identifying
+	}
+
+	return response
+}
+
+func (handler *inspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	defer recoverPanic(w, r)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, found := handler.live.load().forRequest(r, inspectRoute)
+	if !found {
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
+	}
+
+	// Inspection runs the same parsers on untrusted input as uploads, so the same limits apply
+	config := state.config
+	if _, err := config.apiKeyOf(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		log.Println("    rejected inspection: " + err.Error())
+		return
+	}
+
+	fileData, _, ok := readFormFile(w, r, config)
+	if !ok {
+		return
+	}
+
+	// The scrubbed data is discarded, only the report is of interest
+	_, report, err := state.exifScrubber.ScrubExif(fileData)
+	if err == exifscrubber.ErrUnknownFileType {
+		http.Error(w, "unsupported file type", http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		http.Error(w, "could not parse EXIF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newInspectResponse(report))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func newFileRequest(t *testing.T, method string, target string, fileName string) *http.Request {
+	fileData, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(fileData)
+	writer.Close()
+
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestInspectHandler(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := inspectHandler{live: newReloadableConfig(config)}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newFileRequest(t, http.MethodPost, "/inspect", "fixtures/gps.jpg"))
+	assertEqual(w.Code, http.StatusOK, t)
+
+	var response inspectResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if !response.HasGps || response.KeptGps {
+		t.Errorf("GPS tags not reported as present and removed")
+	}
+
+	if !response.HasIdentifying || response.KeptIdentifying {
+		t.Errorf("identifying tags not reported as present and removed")
+	}
+
+	gpsTags := response.Ifds["IFD/GPSInfo"]
+	if len(gpsTags) == 0 {
+		t.Errorf("no tags listed for the GPS IFD")
+	}
+	for _, tag := range gpsTags {
+		if !tag.Gps {
+			t.Errorf("tag %s not flagged as GPS", tag.TagName)
+		}
+	}
+
+	total := 0
+	for _, tags := range response.Ifds {
+		total += len(tags)
+	}
+	assertEqual(total, response.RemovedCount+response.KeptCount, t)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newFileRequest(t, http.MethodPost, "/inspect", "README.md"))
+	assertEqual(w.Code, http.StatusUnsupportedMediaType, t)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inspect", nil))
+	assertEqual(w.Code, http.StatusMethodNotAllowed, t)
+}
+
+func TestInspectHandlerLimits(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.RequireApiKey = true
+	config.ApiKeys = []ApiKey{{Name: "team", Secret: "t0ken", ScrubPolicy: ScrubPolicyAlways}}
+	handler := inspectHandler{live: newReloadableConfig(config)}
+	inspect := func(secret string) *httptest.ResponseRecorder {
+		r := newFileRequest(t, http.MethodPost, "/inspect", "fixtures/gps.jpg")
+		if secret != "" {
+			r.Header.Set("Authorization", bearerPrefix+secret)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Inspection must not be a way around the API key requirement of uploads
+	w := inspect("")
+	assertEqual(w.Code, http.StatusUnauthorized, t)
+
+	w = inspect("t0ken")
+	assertEqual(w.Code, http.StatusOK, t)
+
+	// The fixture exceeds the size limit
+	config.MaxUploadSize = 10
+	w = inspect("t0ken")
+	assertEqual(w.Code, http.StatusRequestEntityTooLarge, t)
+}
@@ -1,62 +1,266 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
-
-	"github.com/leon-richardt/jaf/exifscrubber"
 )
 
 const allowedChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 
+const (
+	commandServe       = "serve"
+	commandScrub       = "scrub"
+	commandInspect     = "inspect"
+	commandCheckConfig = "check-config"
+)
+
 var config Config
 
 type parameters struct {
 	configFile string
+	// Whether the config file was given explicitly; the default one may be missing
+	configFileSet bool
+	// Config values given as flags, in the order they were given
+	configFlags []flagValue
+	// Print the effective config instead of running the command
+	printConfig bool
+	// Only used by the serve command
+	watchConfig bool
+	// Only used by the scrub command
+	dryRun           bool
+	outDir           string
+	scrubUploads     bool
+	includeOriginals bool
+	// Positional arguments following the flags
+	args []string
 }
 
-func parseParams() *parameters {
-	configFile := flag.String("configFile", "jaf.conf", "path to config file")
-	flag.Parse()
+func parseParams(command string, args []string) *parameters {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s %s:\n", os.Args[0], command)
+		flags.PrintDefaults()
+	}
 
 	retval := &parameters{}
-	retval.configFile = *configFile
+	flags.StringVar(&retval.configFile, "configFile", "jaf.conf", "path to config file")
+	flags.BoolVar(
+		&retval.printConfig,
+		"print-config",
+		false,
+		"print the effective config after applying the config file, environment and flags",
+	)
+	registerConfigFlags(flags, &retval.configFlags)
+
+	if command == commandServe {
+		flags.BoolVar(
+			&retval.watchConfig,
+			"watch-config",
+			false,
+			"reload the config file when it changes (it is always reloaded on SIGHUP)",
+		)
+	}
+
+	if command == commandScrub {
+		flags.BoolVar(&retval.dryRun, "dry-run", false, "only report what would be removed")
+		flags.StringVar(
+			&retval.outDir,
+			"out-dir",
+			"",
+			"directory to write scrubbed files to instead of modifying them in place",
+		)
+		flags.BoolVar(
+			&retval.scrubUploads,
+			"uploads",
+			false,
+			"scrub the uploads in the FileDirs of the config and its profiles",
+		)
+		flags.BoolVar(
+			&retval.includeOriginals,
+			"include-originals",
+			false,
+			"with -uploads, also scrub FileDirs that may hold originals kept on purpose",
+		)
+	}
+
+	flags.Parse(args)
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "configFile" {
+			retval.configFileSet = true
+		}
+	})
+	retval.args = flags.Args()
 	return retval
 }
 
+// Loads the config from the config file, the environment and the flags and validates it. Whether
+// the FileDir is usable is only checked if `checkFileDir` is set.
+func (params *parameters) loadConfig(checkFileDir bool) (*Config, error) {
+	config, err := LoadConfig(params.configFile, !params.configFileSet, params.configFlags)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config: %w", err)
+	}
+
+	err = config.validate(checkFileDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags] [args]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintf(os.Stderr, "  %-12s start the upload server (default)\n", commandServe)
+	fmt.Fprintf(os.Stderr, "  %-12s scrub EXIF from local files: %s %s [flags] <files or directories...>\n",
+		commandScrub, os.Args[0], commandScrub)
+	fmt.Fprintf(os.Stderr, "  %-12s show the EXIF tags of a file and what would be removed: %s %s [flags] <file>\n",
+		commandInspect, os.Args[0], commandInspect)
+	fmt.Fprintf(os.Stderr, "  %-12s check the config and report every problem\n", commandCheckConfig)
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	log.SetPrefix("jaf > ")
 
-	params := parseParams()
+	// Without an explicit command, start the server so that existing invocations keep working
+	command := commandServe
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	var run func(params *parameters) error
+	switch command {
+	case commandServe:
+		run = runServe
+	case commandScrub:
+		run = runScrub
+	case commandInspect:
+		run = runInspect
+	case commandCheckConfig:
+		run = runCheckConfig
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	params := parseParams(command, args)
+	if params.printConfig {
+		run = runPrintConfig
+	}
+
+	if err := run(params); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	// Read config
-	config, err := ConfigFromFile(params.configFile)
+func runPrintConfig(params *parameters) error {
+	config, err := params.loadConfig(false)
 	if err != nil {
-		log.Fatalf("could not parse config file: %s\n", err.Error())
+		return err
 	}
 
-	handler := uploadHandler{
-		config: config,
+	return config.Write(os.Stdout)
+}
+
+func runCheckConfig(params *parameters) error {
+	_, err := params.loadConfig(true)
+	if err != nil {
+		return err
 	}
 
-	if config.ScrubExif {
-		scrubber := exifscrubber.NewExifScrubber(config.ExifAllowedIds, config.ExifAllowedPaths)
-		handler.exifScrubber = &scrubber
+	fmt.Println("config is valid")
+	return nil
+}
+
+func runServe(params *parameters) error {
+	config, err := params.loadConfig(true)
+	if err != nil {
+		return err
 	}
 
+	// Left behind by uploads aborted when the process was killed
+	removeTempFiles(config)
+
+	live := newReloadableConfig(config)
+	go reloadOnChange(live, params, params.watchConfig)
+
 	// Start server
 	uploadServer := &http.Server{
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		Addr:         fmt.Sprintf(":%d", config.Port),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	if config.TLSCertFile != "" {
+		certs, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load TLS certificate: %w", err)
+		}
+
+		uploadServer.TLSConfig = newTlsConfig(certs)
+	}
+
+	listener, err := listen(config)
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+
+	if config.TLSRedirectPort != 0 {
+		redirectServer, err := startHttpsRedirect(config)
+		if err != nil {
+			return fmt.Errorf("could not listen for HTTPS redirects: %w", err)
+		}
+
+		// Redirects are answered immediately, so there is nothing to wait for
+		uploadServer.RegisterOnShutdown(func() { redirectServer.Close() })
+	}
+
+	if uploadServer.TLSConfig != nil {
+		log.Printf("starting jaf on %s (HTTPS)\n", listener.Addr())
+	} else {
+		log.Printf("starting jaf on %s\n", listener.Addr())
+	}
+	upload := &uploadHandler{live: live}
+	inspect := &inspectHandler{live: live}
+	// Paths with a trailing slash select a profile, e.g., "/upload/internal"
+	http.Handle(uploadRoute, upload)
+	http.Handle(uploadRoute+"/", upload)
+	http.Handle(inspectRoute, inspect)
+	http.Handle(inspectRoute+"/", inspect)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	shutdownResult := make(chan error, 1)
+	go func() {
+		shutdownResult <- shutdownOnSignal(uploadServer, live, signals)
+	}()
+
+	if uploadServer.TLSConfig != nil {
+		// The certificate is provided by the TLSConfig
+		err = uploadServer.ServeTLS(listener, "", "")
+	} else {
+		err = uploadServer.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not serve on %s: %w", listener.Addr(), err)
 	}
 
-	log.Printf("starting jaf on port %d\n", config.Port)
-	http.Handle("/upload", &handler)
-	uploadServer.ListenAndServe()
+	// Serve returns as soon as shutting down starts, wait for uploads to finish
+	return <-shutdownResult
 }
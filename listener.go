@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// First file descriptor passed by systemd socket activation, see sd_listen_fds(3)
+const systemdListenFdsStart = 3
+
+// Determines what kind of socket jaf listens on
+type ListenMode string
+
+const (
+	// TCP on BindAddress and Port
+	ListenModeTcp ListenMode = "tcp"
+	// Unix domain socket at SocketPath, e.g., for a reverse proxy on the same machine
+	ListenModeUnix ListenMode = "unix"
+	// Socket passed by systemd via LISTEN_FDS, see systemd.socket(5)
+	ListenModeSystemd ListenMode = "systemd"
+)
+
+func ParseListenMode(s string) (ListenMode, error) {
+	switch mode := ListenMode(s); mode {
+	case ListenModeTcp, ListenModeUnix, ListenModeSystemd:
+		return mode, nil
+	default:
+		return "", errors.Errorf(
+			"unknown listen mode: \"%s\" (expected \"%s\", \"%s\" or \"%s\")",
+			s,
+			ListenModeTcp,
+			ListenModeUnix,
+			ListenModeSystemd,
+		)
+	}
+}
+
+// Opens the socket selected by the Listen key of the config
+func listen(config *Config) (net.Listener, error) {
+	switch config.Listen {
+	case ListenModeUnix:
+		return listenUnix(config.SocketPath, config.SocketMode, config.SocketOwner)
+	case ListenModeSystemd:
+		return listenSystemd(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"))
+	default:
+		return net.Listen("tcp", config.listenAddress())
+	}
+}
+
+// Listens on a Unix domain socket at `path` with the given permissions and owner. A socket left
+// behind at `path`, e.g., because jaf was killed, is replaced; any other file is left alone.
+func listenUnix(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	uid, gid, err := parseSocketOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is created according to the umask, so there is a short window in which it may be
+	// more permissive than configured. Put the socket in a directory only the reverse proxy can
+	// access if that matters.
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// Returns the socket passed by systemd socket activation, given the values of the LISTEN_PID and
+// LISTEN_FDS environment variables. Exactly one socket must be passed.
+func listenSystemd(listenPid string, listenFds string) (net.Listener, error) {
+	if listenPid != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("no socket passed by systemd (LISTEN_PID does not match)")
+	}
+
+	count, err := strconv.Atoi(listenFds)
+	if err != nil || count < 1 {
+		return nil, errors.Errorf("no socket passed by systemd (LISTEN_FDS is \"%s\")", listenFds)
+	}
+
+	if count > 1 {
+		return nil, errors.Errorf("expected a single socket from systemd, got %d", count)
+	}
+
+	// Processes started by jaf must not think the socket is meant for them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(systemdListenFdsStart, "systemd socket")
+	defer file.Close()
+
+	// Duplicates the descriptor, so closing the inherited one does not close the listener
+	return net.FileListener(file)
+}
+
+// Parses a SocketOwner value like "user", "user:group" or ":group". Names and numeric IDs are
+// accepted. Returns -1 for parts that are not given, as expected by os.Chown.
+func parseSocketOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
+	}
+
+	userName, groupName, _ := strings.Cut(owner, ":")
+
+	if userName != "" {
+		id := userName
+		if _, err := strconv.Atoi(userName); err != nil {
+			found, err := user.Lookup(userName)
+			if err != nil {
+				return -1, -1, errors.Errorf("unknown user \"%s\"", userName)
+			}
+			id = found.Uid
+		}
+
+		uid, _ = strconv.Atoi(id)
+	}
+
+	if groupName != "" {
+		id := groupName
+		if _, err := strconv.Atoi(groupName); err != nil {
+			found, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, errors.Errorf("unknown group \"%s\"", groupName)
+			}
+			id = found.Gid
+		}
+
+		gid, _ = strconv.Atoi(id)
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jaf.sock")
+
+	// Left behind by a previous run that was killed
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	config := defaultConfig()
+	config.Listen = ListenModeUnix
+	config.SocketPath = path
+	config.SocketMode = 0o600
+
+	listener, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(info.Mode().Perm(), os.FileMode(0o600), t)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})}
+	go server.Serve(listener)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://jaf/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertEqual(resp.StatusCode, http.StatusTeapot, t)
+
+	// The socket must be removed on shutdown
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket still exists after shutdown: %v", err)
+	}
+}
+
+func TestListenUnixKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jaf.sock")
+	if err := os.WriteFile(path, []byte("not a socket"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if listener, err := listenUnix(path, 0o660, ""); err == nil {
+		listener.Close()
+		t.Error("regular file replaced by a socket")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Error("regular file removed:", err)
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	type tType struct {
+		listenPid string
+		listenFds string
+	}
+
+	// Only the error cases can be tested without a socket at file descriptor 3
+	tests := []tType{
+		{listenPid: "", listenFds: ""},
+		{listenPid: "1", listenFds: "1"},
+		{listenPid: pid, listenFds: "0"},
+		{listenPid: pid, listenFds: "two"},
+		{listenPid: pid, listenFds: "2"},
+	}
+
+	for _, test := range tests {
+		if listener, err := listenSystemd(test.listenPid, test.listenFds); err == nil {
+			listener.Close()
+			t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s accepted", test.listenPid, test.listenFds)
+		}
+	}
+}
+
+func TestParseSocketOwner(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("cannot look up the current user:", err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Skip("cannot look up the current group:", err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	type tType struct {
+		owner string
+		uid   int
+		gid   int
+	}
+
+	tests := []tType{
+		{owner: "", uid: -1, gid: -1},
+		{owner: current.Username, uid: uid, gid: -1},
+		{owner: ":" + group.Name, uid: -1, gid: gid},
+		{owner: current.Username + ":" + group.Name, uid: uid, gid: gid},
+		{owner: "1234:5678", uid: 1234, gid: 5678},
+	}
+
+	for _, test := range tests {
+		haveUid, haveGid, err := parseSocketOwner(test.owner)
+		if err != nil {
+			t.Errorf("owner \"%s\" rejected: %s", test.owner, err)
+			continue
+		}
+		assertEqual(haveUid, test.uid, t)
+		assertEqual(haveGid, test.gid, t)
+	}
+
+	if _, _, err := parseSocketOwner("no-such-user-for-jaf"); err == nil {
+		t.Error("unknown user accepted")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Name under which uploads not matching any profile are logged. Cannot be used for a profile.
+const defaultProfileName = "default"
+
+// Key listing the hosts whose uploads use a profile. Only valid in profile sections.
+const hostsKey = "Hosts"
+
+// Profile names end up in URL paths, e.g., "/upload/internal"
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Settings for uploads to a set of hosts or to "/upload/<name>", e.g., to serve a public and an
+// internal sharing domain from one instance. A profile starts from the base config, after all
+// sources have been applied, and overrides some of its keys.
+type Profile struct {
+	Name string
+	// Lower-case host names (without port) whose uploads use this profile
+	Hosts []string
+	// The base config with the settings of the profile applied
+	Config *Config
+}
+
+// A profile as read from the config file
+type profileSpec struct {
+	name string
+	// Where the profile was declared, e.g., "jaf.conf:30"
+	source string
+	hosts  []string
+	values []profileValue
+}
+
+// A value of a profile, applied once the base config is complete
+type profileValue struct {
+	field *configField
+	apply func(config *Config)
+}
+
+// Starts the profile declared by a "[profile <name>]" line. Returns the profile the following
+// lines belong to.
+func (config *Config) addProfileSection(line string, source string) *profileSpec {
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(line, sectionPrefix), "]"))
+	if !strings.HasSuffix(line, "]") || len(fields) != 2 || fields[0] != "profile" {
+		config.addProblem(
+			source,
+			"profile",
+			errors.Errorf("expected \"[profile <name>]\", got \"%s\"", line),
+		)
+
+		// Swallow the lines of the section instead of applying them to the base config
+		return &profileSpec{}
+	}
+
+	return config.addProfileSpec(fields[1], source)
+}
+
+// Registers a new profile named `name`. Invalid profiles are recorded as problems and returned
+// without being registered.
+func (config *Config) addProfileSpec(name string, source string) *profileSpec {
+	spec := &profileSpec{name: name, source: source}
+
+	if !profileNamePattern.MatchString(name) {
+		config.addProblem(source, "profile", errors.Errorf(
+			"profile name \"%s\" may only contain letters, digits, \"-\" and \"_\"",
+			name,
+		))
+		return spec
+	}
+
+	if name == defaultProfileName {
+		config.addProblem(source, "profile", errors.Errorf("profile name \"%s\" is reserved", name))
+		return spec
+	}
+
+	for _, existing := range config.profileSpecs {
+		if existing.name == name {
+			config.addProblem(source, "profile", errors.Errorf(
+				"profile \"%s\" is already declared at %s",
+				name,
+				existing.source,
+			))
+			return spec
+		}
+	}
+
+	config.profileSpecs = append(config.profileSpecs, spec)
+	return spec
+}
+
+// Adds a "Key: value" line of a profile section
+func (config *Config) addProfileLine(spec *profileSpec, key string, val string, source string) {
+	if key == hostsKey {
+		spec.hosts = append(spec.hosts, strings.Fields(strings.ToLower(val))...)
+		return
+	}
+
+	field := config.profileField(key, findConfigField(key), source)
+	if field == nil {
+		return
+	}
+
+	spec.values = append(spec.values, profileValue{
+		field: field,
+		apply: func(config *Config) {
+			config.setFrom(field, val, source)
+		},
+	})
+}
+
+// Adds the tables below "profiles" of a structured config file
+func (config *Config) addProfileTables(filePath string, val any, fields map[string]*configField) {
+	profiles, isTable := asTable(val)
+	if !isTable {
+		config.addProblem(
+			fmt.Sprintf("%s (%s)", filePath, profilesPath),
+			"profile",
+			errors.New("expected a table"),
+		)
+		return
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prefix := profilesPath + "." + name
+		spec := config.addProfileSpec(name, fmt.Sprintf("%s (%s)", filePath, prefix))
+
+		table, isTable := asTable(profiles[name])
+		if !isTable {
+			config.addProblem(spec.source, "profile", errors.New("expected a table"))
+			continue
+		}
+
+		vals := make(map[string]any)
+		flattenStructured("", table, fields, vals)
+
+		paths := make([]string, 0, len(vals))
+		for path := range vals {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			val := vals[path]
+			source := fmt.Sprintf("%s (%s.%s)", filePath, prefix, path)
+
+			if path == "hosts" {
+				hosts, err := structuredString(val, true)
+				if err != nil {
+					config.addProblem(source, hostsKey, err)
+					continue
+				}
+
+				spec.hosts = append(spec.hosts, strings.Fields(strings.ToLower(hosts))...)
+				continue
+			}
+
+			field := config.profileField(path, fields[path], source)
+			if field == nil {
+				continue
+			}
+
+			spec.values = append(spec.values, profileValue{
+				field: field,
+				apply: func(config *Config) {
+					config.setStructuredFrom(field, val, source)
+				},
+			})
+		}
+	}
+}
+
+// Returns `field` if it may be set in a profile. Otherwise, records a problem for `key` and returns
+// nil.
+func (config *Config) profileField(key string, field *configField, source string) *configField {
+	if field == nil {
+		config.addProblem(source, key, errors.New("unknown config key"))
+		return nil
+	}
+
+	if field.global {
+		config.addProblem(source, field.key, errors.New("cannot be set per profile"))
+		return nil
+	}
+
+	return field
+}
+
+// Turns the profiles read from the config file into Profiles. Must be called after all sources
+// have been applied to the base config.
+func (config *Config) buildProfiles() {
+	config.Profiles = make([]Profile, 0, len(config.profileSpecs))
+	hostProfiles := make(map[string]string)
+
+	for _, spec := range config.profileSpecs {
+		for _, host := range spec.hosts {
+			if existing, found := hostProfiles[host]; found {
+				config.addProblem(spec.source, hostsKey, errors.Errorf(
+					"host \"%s\" is already used by profile \"%s\"",
+					host,
+					existing,
+				))
+				continue
+			}
+
+			hostProfiles[host] = spec.name
+		}
+
+		profileConfig := config.clone()
+		replaced := make(map[*configField]bool)
+		for _, value := range spec.values {
+			// Repeatable keys replace the values of the base config, like flags do
+			if value.field.reset != nil && !replaced[value.field] {
+				value.field.reset(profileConfig)
+				replaced[value.field] = true
+			}
+
+			value.apply(profileConfig)
+		}
+
+		// Reported along with the problems of the base config
+		config.problems = append(config.problems, profileConfig.problems...)
+		profileConfig.problems = nil
+
+		config.Profiles = append(config.Profiles, Profile{
+			Name:   spec.name,
+			Hosts:  spec.hosts,
+			Config: profileConfig,
+		})
+	}
+}
+
+// Returns a copy of the config without its profiles and problems. Values that are modified in
+// place are copied as well.
+func (config *Config) clone() *Config {
+	clone := *config
+	clone.ApiKeys = append([]ApiKey{}, config.ApiKeys...)
+	clone.Profiles = nil
+	clone.problems = nil
+	clone.profileSpecs = nil
+
+	clone.sources = make(map[string]string, len(config.sources))
+	for key, source := range config.sources {
+		clone.sources[key] = source
+	}
+
+	return &clone
+}
+
+// Returns the state of the profile `r` is meant for: the profile named in the path below `route`,
+// the profile serving the host of the request or the default. Returns false if the path names an
+// unknown profile.
+func (state *serverState) forRequest(r *http.Request, route string) (*serverState, bool) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, route), "/")
+	if name != "" {
+		profile, found := state.profiles[name]
+		return profile, found
+	}
+
+	host := hostOf(r)
+	for _, profile := range state.config.Profiles {
+		for _, profileHost := range profile.Hosts {
+			if profileHost == host {
+				return state.profiles[profile.Name], true
+			}
+		}
+	}
+
+	return state, true
+}
+
+// Returns the lower-case host name `r` was sent to, without port
+func hostOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// No port given
+		host = r.Host
+	}
+
+	return strings.ToLower(host)
+}
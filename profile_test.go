@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfilesFromFile(t *testing.T) {
+	path := writeConfigFile(t, "jaf.conf", `LinkPrefix: https://jaf.example.com/
+LinkLength: 7
+ApiKey: admin s3cr3t always
+
+[profile internal]
+Hosts: Files.Internal.example.com share.internal.example.com
+LinkPrefix: https://files.internal.example.com/
+FileDir: /var/www/jaf-internal/
+ScrubExif: false
+MaxUploadSize: 1048576
+ApiKey: team t0ken optin
+RequireApiKey: true
+
+[profile public]
+Hosts: public.example.com
+`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(len(config.Profiles), 2, t)
+
+	internal := config.Profiles[0]
+	assertEqual(internal.Name, "internal", t)
+	assertEqualSlice(
+		internal.Hosts,
+		[]string{"files.internal.example.com", "share.internal.example.com"},
+		t,
+	)
+	assertEqual(internal.Config.LinkPrefix, "https://files.internal.example.com/", t)
+	assertEqual(internal.Config.FileDir, "/var/www/jaf-internal/", t)
+	assertEqual(internal.Config.ScrubExif, false, t)
+	assertEqual(internal.Config.MaxUploadSize, 1048576, t)
+	assertEqual(internal.Config.RequireApiKey, true, t)
+	// Keys not set in the profile are inherited
+	assertEqual(internal.Config.LinkLength, 7, t)
+	// Repeatable keys replace the values of the base config
+	assertEqualSlice(internal.Config.ApiKeys, []ApiKey{
+		{Name: "team", Secret: "t0ken", ScrubPolicy: ScrubPolicyOptIn},
+	}, t)
+
+	// The base config is not affected by its profiles
+	assertEqual(config.FileDir, "/var/www/jaf/", t)
+	assertEqual(config.ScrubExif, true, t)
+	assertEqual(len(config.ApiKeys), 1, t)
+
+	public := config.Profiles[1]
+	assertEqual(public.Config.LinkPrefix, "https://jaf.example.com/", t)
+
+	// Writing and reading the config again must keep the profiles
+	writtenPath := filepath.Join(t.TempDir(), "written.conf")
+	file, err := os.Create(writtenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.ApiKeys = nil
+	internal.Config.ApiKeys = nil
+	err = config.Write(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := ConfigFromFile(writtenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(len(reread.Profiles), 2, t)
+	for i, profile := range reread.Profiles {
+		assertEqualSlice(profile.Hosts, config.Profiles[i].Hosts, t)
+		for _, field := range configFields {
+			if field.key != "ApiKey" {
+				assertEqualSlice(field.get(profile.Config), field.get(config.Profiles[i].Config), t)
+			}
+		}
+	}
+}
+
+func TestProfilesFromStructuredFile(t *testing.T) {
+	path := writeConfigFile(t, "jaf.toml", `
+[server]
+link_prefix = "https://jaf.example.com/"
+
+[profiles.internal]
+hosts = ["files.internal.example.com"]
+
+[profiles.internal.server]
+link_prefix = "https://files.internal.example.com/"
+
+[profiles.internal.exif]
+scrub = false
+
+[[profiles.internal.api_keys]]
+name = "team"
+secret = "a secret with spaces"
+scrub_policy = "optout"
+`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(len(config.Profiles), 1, t)
+
+	internal := config.Profiles[0]
+	assertEqualSlice(internal.Hosts, []string{"files.internal.example.com"}, t)
+	assertEqual(internal.Config.LinkPrefix, "https://files.internal.example.com/", t)
+	assertEqual(internal.Config.ScrubExif, false, t)
+	assertEqualSlice(internal.Config.ApiKeys, []ApiKey{
+		{Name: "team", Secret: "a secret with spaces", ScrubPolicy: ScrubPolicyOptOut},
+	}, t)
+}
+
+func TestProfileProblems(t *testing.T) {
+	path := writeConfigFile(t, "jaf.conf", `LinkPrefix: https://jaf.example.com/
+
+[profile internal]
+Hosts: files.example.com
+Port: 8080
+Colour: blue
+LinkPrefix: files.example.com
+
+[profile internal]
+[profile other]
+Hosts: files.example.com
+[profile default]
+[internal]
+LinkLength: 0
+`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, _ := config.validate(false).(ConfigErrors)
+
+	type tType struct {
+		source string
+		key    string
+	}
+
+	want := []tType{
+		{source: path + ":5", key: "Port"},
+		{source: path + ":6", key: "Colour"},
+		{source: path + ":9", key: "profile"},
+		{source: path + ":12", key: "profile"},
+		{source: path + ":13", key: "profile"},
+		{source: path + ":10", key: "Hosts"},
+		{source: path + ":7", key: "LinkPrefix"},
+	}
+
+	have := make([]tType, 0, len(problems))
+	for _, problem := range problems {
+		have = append(have, tType{source: problem.Source, key: problem.Key})
+	}
+	assertEqualSlice(have, want, t)
+
+	// Lines of a malformed section must not end up in the base config
+	assertEqual(config.LinkLength, 5, t)
+}
+
+func TestUploadProfiles(t *testing.T) {
+	baseDir := t.TempDir() + "/"
+	internalDir := t.TempDir() + "/"
+	path := writeConfigFile(t, "jaf.conf", "FileDir: "+baseDir+`
+LinkPrefix: https://jaf.example.com/
+
+[profile internal]
+Hosts: files.internal.example.com
+LinkPrefix: https://files.internal.example.com/
+FileDir: `+internalDir+`
+ApiKey: team t0ken optin
+RequireApiKey: true
+
+[profile tiny]
+MaxUploadSize: 1024
+`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := uploadHandler{live: newReloadableConfig(config)}
+	upload := func(target string, host string, secret string) *httptest.ResponseRecorder {
+		r := newFileRequest(t, http.MethodPost, target, "fixtures/gps.jpg")
+		r.Host = host
+		if secret != "" {
+			r.Header.Set("Authorization", bearerPrefix+secret)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := upload("/upload", "jaf.example.com", "")
+	assertEqual(w.Code, http.StatusOK, t)
+	if !strings.HasPrefix(w.Body.String(), "https://jaf.example.com/") {
+		t.Errorf("have link %s, want link of the base config", w.Body.String())
+	}
+
+	// The profile requires an API key, whether selected by host or by path
+	w = upload("/upload", "files.internal.example.com:443", "")
+	assertEqual(w.Code, http.StatusUnauthorized, t)
+	w = upload("/upload/internal", "jaf.example.com", "")
+	assertEqual(w.Code, http.StatusUnauthorized, t)
+
+	w = upload("/upload", "files.internal.example.com", "t0ken")
+	assertEqual(w.Code, http.StatusOK, t)
+	if !strings.HasPrefix(w.Body.String(), "https://files.internal.example.com/") {
+		t.Errorf("have link %s, want link of the profile", w.Body.String())
+	}
+
+	// The fixture exceeds the size limit of the profile
+	w = upload("/upload/tiny", "jaf.example.com", "")
+	assertEqual(w.Code, http.StatusRequestEntityTooLarge, t)
+
+	w = upload("/upload/unknown", "jaf.example.com", "")
+	assertEqual(w.Code, http.StatusNotFound, t)
+
+	// Each upload must have been stored in the directory of its profile
+	for _, dir := range []string{baseDir, internalDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(len(entries), 1, t)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+// How often the config file is checked for changes if watching is enabled
+const configWatchInterval = 2 * time.Second
+
+// The config in effect and the scrubber built from it
+type serverState struct {
+	// Name of the profile, defaultProfileName for the base config
+	name         string
+	config       *Config
+	exifScrubber *exifscrubber.ExifScrubber
+	// States of the profiles of the base config by name, nil for profiles
+	profiles map[string]*serverState
+}
+
+// Builds the state of the base config and all of its profiles. The scrubbers are needed even if
+// ScrubExif is disabled since API keys and inspection may still ask for scrubbing.
+func newServerState(config *Config) *serverState {
+	state := &serverState{
+		name:         defaultProfileName,
+		config:       config,
+		exifScrubber: newExifScrubber(config),
+		profiles:     make(map[string]*serverState, len(config.Profiles)),
+	}
+
+	for _, profile := range config.Profiles {
+		state.profiles[profile.Name] = &serverState{
+			name:         profile.Name,
+			config:       profile.Config,
+			exifScrubber: newExifScrubber(profile.Config),
+		}
+	}
+
+	return state
+}
+
+// Holds the server state so that it can be swapped at runtime. Handlers must load the state once
+// per request so that a request never mixes an old config with a new scrubber.
+type reloadableConfig struct {
+	current atomic.Pointer[serverState]
+	// Serializes reloads triggered by signals and file changes
+	reloadMutex sync.Mutex
+}
+
+func newReloadableConfig(config *Config) *reloadableConfig {
+	live := &reloadableConfig{}
+	live.store(config)
+	return live
+}
+
+func (live *reloadableConfig) load() *serverState {
+	return live.current.Load()
+}
+
+// Swaps in `config` along with the scrubbers built from it
+func (live *reloadableConfig) store(config *Config) {
+	live.current.Store(newServerState(config))
+}
+
+// Swaps in the config returned by `loadConfig`. If it fails, e.g., because the new config is
+// invalid, the current config stays in effect.
+func (live *reloadableConfig) reload(loadConfig func() (*Config, error)) error {
+	live.reloadMutex.Lock()
+	defer live.reloadMutex.Unlock()
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	old := live.load().config
+	for _, field := range configFields {
+		if field.restart && !equalValues(field.get(config), field.get(old)) {
+			log.Printf("%s changed, restart jaf to apply it\n", field.key)
+		}
+	}
+
+	live.store(config)
+	return nil
+}
+
+// Reloads the config whenever the process receives SIGHUP and, if `watch` is set, whenever the
+// config file changes. Does not return.
+func reloadOnChange(live *reloadableConfig, params *parameters, watch bool) {
+	reload := func(reason string) {
+		log.Printf("reloading config (%s)\n", reason)
+		err := live.reload(func() (*Config, error) {
+			return params.loadConfig(true)
+		})
+		if err != nil {
+			log.Printf("could not reload config, keeping the current one: %s\n", err)
+			return
+		}
+
+		log.Println("config reloaded")
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	var changes <-chan time.Time
+	lastModified := modTimeOf(params.configFile)
+	if watch {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		changes = ticker.C
+	}
+
+	for {
+		select {
+		case <-hangups:
+			reload("SIGHUP")
+		case <-changes:
+			modified := modTimeOf(params.configFile)
+			if modified.Equal(lastModified) {
+				continue
+			}
+
+			lastModified = modified
+			reload("config file changed")
+		}
+	}
+}
+
+// Returns the modification time of the file at `path` or the zero time if it does not exist
+func modTimeOf(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
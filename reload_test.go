@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	fileDir := t.TempDir() + "/"
+	path := filepath.Join(t.TempDir(), "jaf.conf")
+	writeConfig := func(content string) {
+		err := os.WriteFile(path, []byte("FileDir: "+fileDir+"\n"+content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params := &parameters{configFile: path, configFileSet: true}
+	loadConfig := func() (*Config, error) {
+		return params.loadConfig(true)
+	}
+
+	writeConfig("LinkPrefix: https://old.example.com/\n")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := newReloadableConfig(config)
+	oldState := live.load()
+
+	writeConfig("LinkPrefix: https://new.example.com/\nExifAllowedPaths: gps\n")
+	if err := live.reload(loadConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	newState := live.load()
+	assertEqual(newState.config.LinkPrefix, "https://new.example.com/", t)
+	assertEqualSlice(newState.config.ExifAllowedPaths, []string{"IFD/GPSInfo/**"}, t)
+	if newState.exifScrubber == oldState.exifScrubber {
+		t.Error("scrubber was not rebuilt")
+	}
+
+	// An invalid config must keep the current one in effect
+	writeConfig("LinkPrefix: not a URL\n")
+	if err := live.reload(loadConfig); err == nil {
+		t.Error("invalid config accepted")
+	}
+
+	if live.load() != newState {
+		t.Error("invalid config was swapped in")
+	}
+}
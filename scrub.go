@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+// Creates an EXIF scrubber as described by the EXIF settings in `config`
+func newExifScrubber(config *Config) *exifscrubber.ExifScrubber {
+	// Warnings go through the standard logger so they carry our log prefix
+	options := exifscrubber.Options{
+		Logger: exifscrubber.NewLogLogger(log.Default()),
+	}
+
+	var scrubber exifscrubber.ExifScrubber
+	if config.ExifMode == exifscrubber.ModeDenylist {
+		scrubber = exifscrubber.NewDenyingExifScrubber(
+			config.ExifDeniedIds,
+			config.ExifDeniedPaths,
+			options,
+		)
+	} else {
+		scrubber = exifscrubber.NewExifScrubber(
+			config.ExifAllowedIds,
+			config.ExifAllowedPaths,
+			options,
+		)
+	}
+
+	if config.ExifCoarsenGps {
+		scrubber.EnableGpsCoarsening(config.ExifGpsDecimals)
+	}
+
+	if config.ExifKeepThumbnail {
+		scrubber.EnableThumbnailKeeping()
+	}
+
+	if config.ExifStripIccProfile {
+		scrubber.EnableIccProfileStripping()
+	}
+
+	if config.ExifBakeOrientation {
+		scrubber.EnableOrientationBaking(config.ExifJpegQuality)
+	}
+
+	return &scrubber
+}
+
+// Scrubs EXIF from `fileData`. If scrubbing fails and the config asks for it, all metadata is
+// stripped instead; no report is returned in that case. Returns the original error if the file
+// could be neither scrubbed nor stripped.
+func scrubFileData(
+	config *Config,
+	scrubber *exifscrubber.ExifScrubber,
+	fileData []byte,
+) ([]byte, *exifscrubber.ScrubReport, error) {
+	scrubbedData, report, err := scrubber.ScrubExif(fileData)
+	if err == nil {
+		return scrubbedData, report, nil
+	}
+
+	if err == exifscrubber.ErrUnknownFileType || !config.ExifStripOnError {
+		return nil, nil, err
+	}
+
+	// Fall back to removing all metadata without interpreting it
+	strippedData, stripErr := scrubber.StripMetadata(fileData)
+	if stripErr == nil {
+		stripErr = scrubber.Verify(strippedData)
+	}
+
+	if stripErr != nil {
+		log.Printf("could not strip metadata from file either: %s", stripErr.Error())
+		return nil, nil, err
+	}
+
+	log.Printf("could not scrub EXIF from file, stripped all metadata instead: %s", err.Error())
+	return strippedData, nil, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Shuts `server` down once a signal arrives on `signals`, giving uploads in progress the
+// configured ShutdownTimeout to finish. Connections still open afterwards are closed and the
+// temporary files their uploads may have left behind are removed. Returns an error if uploads had
+// to be aborted.
+func shutdownOnSignal(server *http.Server, live *reloadableConfig, signals <-chan os.Signal) error {
+	sig := <-signals
+	config := live.load().config
+	log.Printf("received %s, waiting up to %s for uploads in progress\n", sig, config.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err == nil {
+		log.Println("all uploads finished, shutting down")
+		return nil
+	}
+
+	server.Close()
+	removeTempFiles(config)
+	return fmt.Errorf("aborted uploads still in progress after %s: %w", config.ShutdownTimeout, err)
+}
+
+// Removes temporary files left behind by uploads that were aborted while being written, e.g.,
+// because the process was killed, from the FileDirs of the config and its profiles
+func removeTempFiles(config *Config) {
+	dirs := map[string]bool{config.FileDir: true}
+	for _, profile := range config.Profiles {
+		dirs[profile.Config.FileDir] = true
+	}
+
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("could not look for temporary files in \"%s\": %s\n", dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !isTempFileName(entry.Name()) {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if err := os.Remove(path); err != nil {
+				log.Printf("could not remove temporary file \"%s\": %s\n", path, err)
+				continue
+			}
+
+			log.Printf("removed partially written file \"%s\"\n", path)
+		}
+	}
+}
+
+// Whether `name` is the name of a temporary file created by writeFileAtomically
+func isTempFileName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Starts a server whose requests block until `release` is closed. Returns the URL to request.
+func startBlockingServer(
+	t *testing.T,
+	started chan<- bool,
+	release <-chan bool,
+) (*http.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- true
+			<-release
+		}),
+	}
+	go server.Serve(listener)
+
+	return server, "http://" + listener.Addr().String()
+}
+
+func TestShutdownDrainsUploads(t *testing.T) {
+	config := defaultConfig()
+	config.ShutdownTimeout = 5 * time.Second
+
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	server, url := startBlockingServer(t, started, release)
+
+	responses := make(chan int, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err != nil {
+			responses <- 0
+			return
+		}
+
+		response.Body.Close()
+		responses <- response.StatusCode
+	}()
+	<-started
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	result := make(chan error, 1)
+	go func() {
+		result <- shutdownOnSignal(server, newReloadableConfig(config), signals)
+	}()
+
+	// The request in progress must be allowed to finish
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-result; err != nil {
+		t.Error(err)
+	}
+	assertEqual(<-responses, http.StatusOK, t)
+}
+
+func TestShutdownAbortsAfterTimeout(t *testing.T) {
+	config := defaultConfig()
+	config.FileDir = t.TempDir() + "/"
+	config.ShutdownTimeout = 50 * time.Millisecond
+
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	defer close(release)
+	server, url := startBlockingServer(t, started, release)
+
+	go http.Get(url)
+	<-started
+
+	// Stands in for an upload being written while the server shuts down
+	tempFile, err := os.CreateTemp(config.FileDir, ".abcde.jpg.*"+tempFileSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile.Close()
+
+	if err := os.WriteFile(config.FileDir+"fghij.jpg", []byte{}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	if err := shutdownOnSignal(server, newReloadableConfig(config), signals); err == nil {
+		t.Error("aborted upload not reported")
+	}
+
+	// Only the temporary file must have been removed
+	entries, err := os.ReadDir(config.FileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 1, t)
+	assertEqual(entries[0].Name(), "fghij.jpg", t)
+}
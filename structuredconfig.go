@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-errors/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Table of structured config files holding a table per profile
+const profilesPath = "profiles"
+
+// Decodes a structured config file into nested maps
+type structuredDecoder func(data []byte) (map[string]any, error)
+
+// Returns the decoder for the structured format of the file at `filePath` (TOML, YAML or JSON),
+// determined by its extension. Returns nil for all other files.
+func structuredDecoderFor(filePath string) structuredDecoder {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".toml":
+		return decodeToml
+	case ".yaml", ".yml":
+		return decodeYaml
+	case ".json":
+		return decodeJson
+	default:
+		return nil
+	}
+}
+
+func decodeToml(data []byte) (map[string]any, error) {
+	doc := make(map[string]any)
+	_, err := toml.Decode(string(data), &doc)
+	return doc, err
+}
+
+func decodeYaml(data []byte) (map[string]any, error) {
+	doc := make(map[string]any)
+	err := yaml.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func decodeJson(data []byte) (map[string]any, error) {
+	doc := make(map[string]any)
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keeps integers from being turned into floats
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	return doc, err
+}
+
+// Applies a structured config file. Keys are looked up by their structured path, e.g.,
+// "exif.allowed_ids" for the table "exif" and its key "allowed_ids".
+func (config *Config) applyStructuredFile(filePath string, decode structuredDecoder) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	doc, err := decode(data)
+	if err != nil {
+		return errors.Errorf("%s: %s", filePath, err)
+	}
+
+	fields := make(map[string]*configField, len(configFields))
+	for i := range configFields {
+		fields[configFields[i].structuredPath()] = &configFields[i]
+	}
+
+	if profiles, found := doc[profilesPath]; found {
+		delete(doc, profilesPath)
+		config.addProfileTables(filePath, profiles, fields)
+	}
+
+	vals := make(map[string]any)
+	flattenStructured("", doc, fields, vals)
+
+	// Report problems in a stable order
+	paths := make([]string, 0, len(vals))
+	for path := range vals {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		source := fmt.Sprintf("%s (%s)", filePath, path)
+
+		field, found := fields[path]
+		if !found {
+			config.addProblem(source, path, errors.New("unknown config key"))
+			continue
+		}
+
+		config.setStructuredFrom(field, vals[path], source)
+	}
+
+	return nil
+}
+
+// Stores the values of the nested maps in `doc` in `vals` by their dot-separated path. Maps are
+// only descended into if they are not a known key themselves.
+func flattenStructured(
+	prefix string,
+	doc map[string]any,
+	fields map[string]*configField,
+	vals map[string]any,
+) {
+	for key, val := range doc {
+		path := prefix + key
+
+		table, isTable := asTable(val)
+		if _, known := fields[path]; isTable && !known {
+			flattenStructured(path+".", table, fields, vals)
+			continue
+		}
+
+		vals[path] = val
+	}
+}
+
+func (config *Config) setStructuredFrom(field *configField, val any, source string) {
+	items, isArray := asArray(val)
+	if field.reset == nil || !isArray {
+		config.setStructuredItemFrom(field, val, source)
+		return
+	}
+
+	// Each array element is an occurrence of a repeatable key
+	for _, item := range items {
+		config.setStructuredItemFrom(field, item, source)
+	}
+}
+
+func (config *Config) setStructuredItemFrom(field *configField, val any, source string) {
+	if table, isTable := asTable(val); isTable && field.setTable != nil {
+		err := field.setTable(config, table)
+		if err != nil {
+			config.addProblem(source, field.key, err)
+			return
+		}
+
+		config.sources[field.key] = source
+		return
+	}
+
+	if _, isString := val.(string); field.stringOnly && !isString {
+		config.addProblem(source, field.key, errors.Errorf("expected a quoted string, got %v", val))
+		return
+	}
+
+	str, err := structuredString(val, field.isList)
+	if err != nil {
+		config.addProblem(source, field.key, err)
+		return
+	}
+
+	config.setFrom(field, str, source)
+}
+
+// Formats a scalar from a structured config file the way it would be written in a "Key: value"
+// line. Arrays are only allowed for list keys and are joined with spaces.
+func structuredString(val any, isList bool) (string, error) {
+	if items, isArray := asArray(val); isArray {
+		if !isList {
+			return "", errors.New("expected a single value, got an array")
+		}
+
+		strs := make([]string, 0, len(items))
+		for _, item := range items {
+			str, err := structuredString(item, false)
+			if err != nil {
+				return "", err
+			}
+
+			strs = append(strs, str)
+		}
+
+		return strings.Join(strs, " "), nil
+	}
+
+	switch val := val.(type) {
+	case nil:
+		// Keys without a value in YAML
+		return "", nil
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case uint64:
+		return strconv.FormatUint(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case json.Number:
+		return val.String(), nil
+	default:
+		return "", errors.Errorf("unexpected value: %v", val)
+	}
+}
+
+// Returns `val` as a table if it is one. YAML decodes nested tables as map[interface{}]interface{}.
+func asTable(val any) (map[string]any, bool) {
+	switch val := val.(type) {
+	case map[string]any:
+		return val, true
+	case map[any]any:
+		table := make(map[string]any, len(val))
+		for key, item := range val {
+			table[fmt.Sprint(key)] = item
+		}
+
+		return table, true
+	default:
+		return nil, false
+	}
+}
+
+// Returns `val` as an array if it is one. TOML decodes arrays of tables as
+// []map[string]interface{}.
+func asArray(val any) ([]any, bool) {
+	switch val := val.(type) {
+	case []any:
+		return val, true
+	case []map[string]any:
+		items := make([]any, 0, len(val))
+		for _, item := range val {
+			items = append(items, item)
+		}
+
+		return items, true
+	default:
+		return nil, false
+	}
+}
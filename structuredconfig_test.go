@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const exampleYaml = `
+server:
+  port: 4711
+  socket_path: /run/jaf/jaf.sock
+  socket_mode: "0660"
+  link_prefix: https://jaf.example.com/
+  file_dir: /var/www/jaf/
+  link_length: 5
+exif:
+  scrub: true
+  mode: allowlist
+  allowed_ids: [0x0112, 274]
+  allowed_paths: [IFD/Orientation]
+  denied_ids: ["0xa431"]
+  denied_paths: owner IFD/Exif/UserComment
+  coarsen_gps: false
+  gps_decimals: 2
+  bake_orientation: false
+  jpeg_quality: 90
+  keep_thumbnail: false
+  strip_icc_profile: false
+  strip_on_error: false
+  abort_on_error: true
+`
+
+const exampleJson = `{
+	"server": {
+		"port": 4711,
+		"socket_path": "/run/jaf/jaf.sock",
+		"link_prefix": "https://jaf.example.com/",
+		"file_dir": "/var/www/jaf/",
+		"link_length": 5
+	},
+	"exif": {
+		"scrub": true,
+		"mode": "allowlist",
+		"allowed_ids": [274, "0x0112"],
+		"allowed_paths": ["IFD/Orientation"],
+		"denied_ids": ["0xa431"],
+		"denied_paths": ["owner", "IFD/Exif/UserComment"],
+		"gps_decimals": 2,
+		"jpeg_quality": 90,
+		"abort_on_error": true
+	}
+}`
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestStructuredConfig(t *testing.T) {
+	want, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{
+		"example.toml",
+		writeConfigFile(t, "jaf.yaml", exampleYaml),
+		writeConfigFile(t, "jaf.json", exampleJson),
+	}
+
+	for _, path := range paths {
+		have, err := ConfigFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := have.validate(false); err != nil {
+			t.Fatal(err)
+		}
+
+		// All formats must be read to the same values
+		for _, field := range configFields {
+			assertEqualSlice(field.get(have), field.get(want), t)
+		}
+	}
+}
+
+func TestStructuredConfigPaths(t *testing.T) {
+	type tType struct {
+		key  string
+		want string
+	}
+
+	tests := []tType{
+		{key: "Port", want: "server.port"},
+		{key: "LinkPrefix", want: "server.link_prefix"},
+		{key: "ScrubExif", want: "exif.scrub"},
+		{key: "ExifAllowedIds", want: "exif.allowed_ids"},
+		{key: "ExifStripIccProfile", want: "exif.strip_icc_profile"},
+		{key: "ApiKey", want: "api_keys"},
+	}
+
+	for _, test := range tests {
+		assertEqual(findConfigField(test.key).structuredPath(), test.want, t)
+	}
+}
+
+func TestStructuredConfigApiKeys(t *testing.T) {
+	path := writeConfigFile(t, "jaf.toml", `
+[[api_keys]]
+name = "photographers"
+secret = "a secret with spaces"
+scrub_policy = "optin"
+
+[[api_keys]]
+name = "bots"
+secret = "t0ken"
+scrub_policy = "always"
+`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqualSlice(config.ApiKeys, []ApiKey{
+		{Name: "photographers", Secret: "a secret with spaces", ScrubPolicy: ScrubPolicyOptIn},
+		{Name: "bots", Secret: "t0ken", ScrubPolicy: ScrubPolicyAlways},
+	}, t)
+}
+
+func TestStructuredConfigProblems(t *testing.T) {
+	path := writeConfigFile(t, "jaf.json", `{
+		"server": {"port": [4711], "colour": "blue"},
+		"exif": {"gps_decimals": 2.5},
+		"api_keys": [{"name": "bots", "scrub_policy": "always"}]
+	}`)
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.validate(false)
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("have error %v, want ConfigErrors", err)
+	}
+
+	keys := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		keys = append(keys, problem.Key)
+	}
+	assertEqualSlice(keys, []string{"ApiKey", "ExifGpsDecimals", "server.colour", "Port"}, t)
+	assertEqual(problems[0].Source, path+" (api_keys)", t)
+
+	// Syntax errors fail loading altogether
+	path = writeConfigFile(t, "broken.toml", "port = ")
+	if _, err := ConfigFromFile(path); err == nil {
+		t.Error("broken file accepted")
+	}
+}
+
+func TestStructuredConfigSocketMode(t *testing.T) {
+	type tType struct {
+		name    string
+		content string
+		valid   bool
+	}
+
+	// Unquoted, both formats read the mode as the decimal number 432
+	tests := []tType{
+		{name: "jaf.yaml", content: "server:\n  socket_mode: 0660\n", valid: false},
+		{name: "jaf.toml", content: "[server]\nsocket_mode = 0o660\n", valid: false},
+		{name: "jaf.yaml", content: "server:\n  socket_mode: \"0660\"\n", valid: true},
+		{name: "jaf.toml", content: "[server]\nsocket_mode = \"0660\"\n", valid: true},
+	}
+
+	for _, test := range tests {
+		config, err := ConfigFromFile(writeConfigFile(t, test.name, test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = config.validate(false)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: numeric socket_mode accepted as %04o", test.name, config.SocketMode)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		assertEqual(config.SocketMode, os.FileMode(0o660), t)
+	}
+}
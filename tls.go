@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Port browsers use for https URLs without an explicit port
+const defaultHttpsPort = 443
+
+// Minimum time between two checks of the certificate files for changes, so that not every handshake
+// has to stat them
+const certCheckInterval = 5 * time.Second
+
+// Returns the TLS settings for serving the certificate of `certs`. Only TLS 1.2 with
+// forward-secret AEAD ciphers and TLS 1.3 are offered.
+func newTlsConfig(certs *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Only applies to TLS 1.2, the cipher suites of TLS 1.3 are not configurable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:   certs.getCertificate,
+	}
+}
+
+// Serves a certificate and key from disk, reloading them whenever either file changes, e.g.,
+// because certbot renewed the certificate
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex sync.Mutex
+	cert  *tls.Certificate
+	// Modification times of the files the current certificate was loaded from
+	certModified time.Time
+	keyModified  time.Time
+	// Modification times of the files that last failed to load
+	failedCertModified time.Time
+	failedKeyModified  time.Time
+	// Time of the last check for changes
+	checked time.Time
+}
+
+// Loads the certificate in `certFile` and its key in `keyFile`. Fails if they cannot be loaded, so
+// that jaf does not start without a certificate.
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := certs.reload(); err != nil {
+		return nil, err
+	}
+
+	certs.checked = time.Now()
+	return certs, nil
+}
+
+// Loads the certificate again if its files changed since it was last loaded. Files that failed to
+// load are not tried again until either of them changes, so the error is only returned once. Must
+// be called with the mutex held once connections are served.
+func (certs *certReloader) reload() error {
+	certModified := modTimeOf(certs.certFile)
+	keyModified := modTimeOf(certs.keyFile)
+	if certs.cert != nil && certModified.Equal(certs.certModified) &&
+		keyModified.Equal(certs.keyModified) {
+		return nil
+	}
+
+	if certs.cert != nil && certModified.Equal(certs.failedCertModified) &&
+		keyModified.Equal(certs.failedKeyModified) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certs.certFile, certs.keyFile)
+	if err != nil {
+		certs.failedCertModified = certModified
+		certs.failedKeyModified = keyModified
+		return err
+	}
+
+	if certs.cert != nil {
+		log.Printf("reloaded TLS certificate from \"%s\"\n", certs.certFile)
+	}
+
+	certs.cert = &cert
+	certs.certModified = certModified
+	certs.keyModified = keyModified
+	return nil
+}
+
+// Implements tls.Config.GetCertificate. The files are checked for changes at most every
+// certCheckInterval. If the changed files cannot be loaded, e.g., because only the certificate has
+// been replaced so far, the previous certificate is served until the files change again.
+func (certs *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs.mutex.Lock()
+	defer certs.mutex.Unlock()
+
+	if time.Since(certs.checked) < certCheckInterval {
+		return certs.cert, nil
+	}
+
+	certs.checked = time.Now()
+	if err := certs.reload(); err != nil {
+		log.Printf("could not reload TLS certificate, keeping the current one: %s\n", err)
+	}
+
+	return certs.cert, nil
+}
+
+// Redirects plain HTTP requests to the same URL on the HTTPS server listening on `httpsPort`
+type httpsRedirectHandler struct {
+	httpsPort int
+}
+
+func (handler *httpsRedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := hostOf(r)
+	if handler.httpsPort != defaultHttpsPort {
+		host = net.JoinHostPort(host, strconv.Itoa(handler.httpsPort))
+	} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+		// IPv6 addresses need brackets even without port
+		host = "[" + host + "]"
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	// Keeps the method, so uploads sent to the wrong scheme are not silently turned into GETs
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
+// Starts a plain HTTP server on TLSRedirectPort that redirects all requests to HTTPS
+func startHttpsRedirect(config *Config) (*http.Server, error) {
+	httpsPort := config.Port
+	if config.Listen != ListenModeTcp {
+		// The port of sockets passed by systemd or proxied Unix sockets is not known
+		httpsPort = defaultHttpsPort
+	}
+
+	address := net.JoinHostPort(trimBrackets(config.BindAddress), strconv.Itoa(config.TLSRedirectPort))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           &httpsRedirectHandler{httpsPort: httpsPort},
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	log.Printf("redirecting plain HTTP requests on %s to HTTPS\n", listener.Addr())
+	go func() {
+		err := server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("could not serve HTTPS redirects on %s: %s\n", listener.Addr(), err)
+		}
+	}()
+
+	return server, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed certificate for `commonName` and its key to `certFile` and `keyFile`
+func writeTestCert(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyFile, keyPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Moves the modification time of `paths` forward so that changes are detected even on file
+// systems with a coarse timestamp resolution
+func touch(t *testing.T, offset time.Duration, paths ...string) {
+	for _, path := range paths {
+		modified := time.Now().Add(offset)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonNameOf(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Error("missing certificate accepted")
+	}
+
+	writeTestCert(t, certFile, keyFile, "old.example.com")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := certs.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(commonNameOf(t, cert), "old.example.com", t)
+
+	// Renewed, e.g., by certbot
+	writeTestCert(t, certFile, keyFile, "new.example.com")
+	touch(t, time.Minute, certFile, keyFile)
+
+	// The files are not checked again right away
+	cert, err = certs.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(commonNameOf(t, cert), "old.example.com", t)
+
+	certs.checked = time.Time{}
+	cert, err = certs.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(commonNameOf(t, cert), "new.example.com", t)
+
+	// A key not matching the certificate must keep the current certificate in effect
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, 2*time.Minute, keyFile)
+
+	b := &bytes.Buffer{}
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	for i := 0; i < 2; i++ {
+		certs.checked = time.Time{}
+		cert, err = certs.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(commonNameOf(t, cert), "new.example.com", t)
+	}
+
+	// The failure is only logged once until the files change again
+	assertEqual(strings.Count(b.String(), "could not reload TLS certificate"), 1, t)
+
+	// Fixed, e.g., once the renewal is complete
+	writeTestCert(t, certFile, keyFile, "fixed.example.com")
+	touch(t, 3*time.Minute, certFile, keyFile)
+
+	certs.checked = time.Time{}
+	cert, err = certs.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(commonNameOf(t, cert), "fixed.example.com", t)
+}
+
+func TestServeTls(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "jaf.example.com")
+
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		TLSConfig: newTlsConfig(certs),
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+	url := "https://" + listener.Addr().String() + uploadRoute
+
+	// Legacy protocol versions must be refused
+	legacy := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS11,
+	}}}
+	if resp, err := legacy.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("TLS 1.1 accepted")
+	}
+
+	modern := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+	}}}
+	resp, err := modern.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertEqual(resp.StatusCode, http.StatusTeapot, t)
+	assertEqual(resp.TLS.PeerCertificates[0].Subject.CommonName, "jaf.example.com", t)
+}
+
+func TestHttpsRedirect(t *testing.T) {
+	type tType struct {
+		httpsPort int
+		target    string
+		want      string
+	}
+
+	tests := []tType{
+		{
+			httpsPort: 443,
+			target:    "http://jaf.example.com/upload?scrub=false",
+			want:      "https://jaf.example.com/upload?scrub=false",
+		},
+		{
+			httpsPort: 443,
+			target:    "http://jaf.example.com:80/upload",
+			want:      "https://jaf.example.com/upload",
+		},
+		{
+			httpsPort: 4711,
+			target:    "http://jaf.example.com/upload/internal",
+			want:      "https://jaf.example.com:4711/upload/internal",
+		},
+		{
+			httpsPort: 443,
+			target:    "http://[::1]:80/upload",
+			want:      "https://[::1]/upload",
+		},
+	}
+
+	for _, test := range tests {
+		handler := &httpsRedirectHandler{httpsPort: test.httpsPort}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.target, nil))
+
+		assertEqual(w.Code, http.StatusPermanentRedirect, t)
+		assertEqual(w.Header().Get("Location"), test.want, t)
+	}
+}
+
+func TestValidateTls(t *testing.T) {
+	config := defaultConfig()
+	config.TLSCertFile = "cert.pem"
+	if err := config.validate(false); err == nil {
+		t.Error("TLSCertFile accepted without TLSKeyFile")
+	}
+
+	config.TLSKeyFile = "key.pem"
+	if err := config.validate(false); err != nil {
+		t.Error(err)
+	}
+
+	config.TLSRedirectPort = config.Port
+	if err := config.validate(false); err == nil {
+		t.Error("TLSRedirectPort accepted on Port")
+	}
+
+	config.TLSRedirectPort = 80
+	if err := config.validate(false); err != nil {
+		t.Error(err)
+	}
+
+	config.TLSCertFile = ""
+	config.TLSKeyFile = ""
+	if err := config.validate(false); err == nil {
+		t.Error("TLSRedirectPort accepted without TLS")
+	}
+}
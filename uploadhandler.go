@@ -1,101 +1,229 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strings"
 
 	"github.com/leon-richardt/jaf/exifscrubber"
 	"github.com/leon-richardt/jaf/extdetect"
 )
 
+// Path of the upload endpoint. Uploads to "/upload/<name>" use the profile named <name>.
+const uploadRoute = "/upload"
+
 type uploadHandler struct {
-	config       *Config
-	exifScrubber *exifscrubber.ExifScrubber
+	live *reloadableConfig
+}
+
+// Response body sent to clients that accept JSON
+type uploadResponse struct {
+	Link        string                    `json:"link"`
+	ScrubReport *exifscrubber.ScrubReport `json:"scrubReport,omitempty"`
 }
 
 func (handler *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	defer recoverPanic(w, r)
+
+	state, found := handler.live.load().forRequest(r, uploadRoute)
+	if !found {
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
+	}
+	config := state.config
 
-	uploadFile, header, err := r.FormFile("file")
+	// Reject unauthorized uploads before reading them
+	apiKey, err := config.apiKeyOf(r)
 	if err != nil {
-		http.Error(w, "could not read uploaded file: "+err.Error(), http.StatusBadRequest)
-		log.Println("    could not read uploaded file: " + err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		log.Println("    rejected upload: " + err.Error())
+		return
+	}
+
+	fileData, header, ok := readFormFile(w, r, config)
+	if !ok {
 		return
 	}
 
-	fileData, err := io.ReadAll(uploadFile)
-	uploadFile.Close()
+	scrub, reason, err := config.scrubDecision(apiKey, r)
 	if err != nil {
-		http.Error(w, "could not read attached file: "+err.Error(), http.StatusInternalServerError)
-		log.Println("    could not read attached file: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Println("    rejected upload: " + err.Error())
 		return
 	}
 
+	uploader := anonymousUploader
+	if apiKey != nil {
+		uploader = apiKey.Name
+	}
+	log.Printf(
+		"upload of \"%s\" by %s to profile %s, scrubbing: %t (%s)\n",
+		header.Filename,
+		uploader,
+		state.name,
+		scrub,
+		reason,
+	)
+
 	// Scrub EXIF, if requested and detectable by us
-	if handler.config.ScrubExif {
-		scrubbedData, err := handler.exifScrubber.ScrubExif(fileData[:])
+	var scrubReport *exifscrubber.ScrubReport
+	if scrub {
+		scrubbedData, report, err := scrubFileData(config, state.exifScrubber, fileData[:])
 
 		if err == nil {
 			// If scrubbing was successful, update what to write to file
 			fileData = scrubbedData
-		} else {
+			scrubReport = report
+			if report != nil {
+				logScrubReport(header.Filename, report)
+			}
+		} else if err != exifscrubber.ErrUnknownFileType {
 			// Unknown file types (not PNG or JPEG) are allowed to contain EXIF, as we don't know
 			// how to handle them. Handling of other errors depends on configuration.
-			if err != exifscrubber.ErrUnknownFileType {
-				if handler.config.ExifAbortOnError {
-					log.Printf("could not scrub EXIF from file, aborting upload: %s", err.Error())
-					http.Error(
-						w,
-						"could not scrub EXIF from file: "+err.Error(),
-						http.StatusInternalServerError,
-					)
-					return
-				}
-
-				// An error occured but we are configured to proceed with the upload anyway
-				log.Printf(
-					"could not scrub EXIF from file but proceeding with upload as configured: %s",
-					err.Error(),
+			if config.ExifAbortOnError {
+				log.Printf("could not scrub EXIF from file, aborting upload: %s", err.Error())
+				http.Error(
+					w,
+					"could not scrub EXIF from file: "+err.Error(),
+					http.StatusInternalServerError,
 				)
+				return
 			}
+
+			// An error occured but we are configured to proceed with the upload anyway
+			log.Printf(
+				"could not scrub EXIF from file but proceeding with upload as configured: %s",
+				err.Error(),
+			)
 		}
 	}
 
-	link, err := generateLink(handler, fileData[:], header.Filename)
+	link, err := generateLink(config, fileData[:], header.Filename)
 	if err != nil {
 		http.Error(w, "could not save file: "+err.Error(), http.StatusInternalServerError)
 		log.Println("    could not save file: " + err.Error())
 		return
 	}
 
-	// Implicitly means code 200
-	w.Write([]byte(link))
+	if !acceptsJson(r) {
+		// Implicitly means code 200
+		w.Write([]byte(link))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadResponse{
+		Link:        link,
+		ScrubReport: scrubReport,
+	})
+}
+
+// Reads the file sent in the form field "file", enforcing MaxUploadSize on the whole request body.
+// Responds with an error and returns false if the file cannot be read.
+func readFormFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *Config,
+) ([]byte, *multipart.FileHeader, bool) {
+	if config.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(config.MaxUploadSize))
+	}
+
+	formFile, header, err := r.FormFile("file")
+	if err == nil {
+		defer formFile.Close()
+		// The multipart parser stops at the closing boundary, but data following it counts towards
+		// the limit as well
+		_, err = io.Copy(io.Discard, r.Body)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(
+			w,
+			fmt.Sprintf("request too large, the limit is %d bytes", config.MaxUploadSize),
+			http.StatusRequestEntityTooLarge,
+		)
+		log.Println("    rejected upload: " + err.Error())
+		return nil, nil, false
+	} else if err != nil {
+		http.Error(w, "could not read uploaded file: "+err.Error(), http.StatusBadRequest)
+		log.Println("    could not read uploaded file: " + err.Error())
+		return nil, nil, false
+	}
+
+	fileData, err := io.ReadAll(formFile)
+	if err != nil {
+		http.Error(w, "could not read attached file: "+err.Error(), http.StatusInternalServerError)
+		log.Println("    could not read attached file: " + err.Error())
+		return nil, nil, false
+	}
+
+	return fileData, header, true
+}
+
+// Turns a panic, e.g., in one of the third-party parsers handling untrusted input, into an
+// internal server error. Must be deferred directly by the handler.
+func recoverPanic(w http.ResponseWriter, r *http.Request) {
+	if err := recover(); err != nil {
+		log.Printf("panic while handling %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Whether the client asked for a JSON response instead of the plain link
+func acceptsJson(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func logScrubReport(fileName string, report *exifscrubber.ScrubReport) {
+	log.Printf(
+		"scrubbed EXIF from \"%s\": %d tags removed, %d tags kept, GPS kept: %t\n",
+		fileName,
+		report.RemovedCount(),
+		report.KeptCount(),
+		report.KeptGps(),
+	)
+
+	for _, tag := range report.Tags {
+		action := "kept"
+		if tag.Removed {
+			action = "removed"
+		}
+
+		log.Printf("    %s/%s (0x%04x): %s\n", tag.IfdPath, tag.TagName, tag.TagId, action)
+	}
 }
 
 // Generates a valid link to uploadFile with the specified file extension.
 // Returns the link or an error in case of failure.
 // Does not close the passed file pointer.
-func generateLink(handler *uploadHandler, fileData []byte, fileName string) (string, error) {
+func generateLink(config *Config, fileData []byte, fileName string) (string, error) {
 	ext := extdetect.BuildFileExtension(fileData, fileName)
 
 	// Find an unused file name
 	var fullFileName string
 	var savePath string
 	for {
-		fileStem := createRandomFileName(handler.config.LinkLength)
+		fileStem := createRandomFileName(config.LinkLength)
 		fullFileName = fileStem + ext
-		savePath = handler.config.FileDir + fullFileName
+		savePath = config.FileDir + fullFileName
 
 		if !fileExists(savePath) {
 			break
 		}
 	}
 
-	link := handler.config.LinkPrefix + fullFileName
+	link := config.LinkPrefix + fullFileName
 
 	err := saveFile(fileData[:], savePath)
 	if err != nil {
@@ -105,9 +233,10 @@ func generateLink(handler *uploadHandler, fileData []byte, fileName string) (str
 	return link, nil
 }
 
+// Saves the upload atomically so that an upload aborted while being written never shows up under
+// its link
 func saveFile(fileData []byte, name string) error {
-	err := os.WriteFile(name, fileData, 0o644)
-	return err
+	return writeFileAtomically(name, fileData)
 }
 
 func fileExists(fileName string) bool {
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandlerRecoversPanic(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.FileDir = t.TempDir() + "/"
+
+	// Scrubbing without a scrubber panics, standing in for a panic in one of the parsers
+	handler := uploadHandler{live: &reloadableConfig{}}
+	handler.live.current.Store(&serverState{config: config})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newFileRequest(t, http.MethodPost, "/upload", "fixtures/gps.jpg"))
+	assertEqual(w.Code, http.StatusInternalServerError, t)
+
+	// The unscrubbed file must not have been stored
+	entries, err := os.ReadDir(config.FileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 0, t)
+}
+
+func TestUploadHandlerScrubErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// Cut off after the EXIF segment, so neither scrubbing nor stripping can make sense of it
+	danglingData, err := os.ReadFile("fixtures/dangling-ifd.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentEnd := 4 + (int(danglingData[4])<<8 | int(danglingData[5]))
+	truncatedFile := filepath.Join(dir, "truncated.jpg")
+	if err := os.WriteFile(truncatedFile, danglingData[:segmentEnd], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Displayed by browsers although the EOI marker is missing
+	gpsData, err := os.ReadFile("fixtures/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noEoiFile := filepath.Join(dir, "no-eoi.jpg")
+	if err := os.WriteFile(noEoiFile, gpsData[:len(gpsData)-2], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	textFile := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(textFile, []byte("Exif\x00\x00 in plain text"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type tType struct {
+		file        string
+		stripOnErr  bool
+		abortOnErr  bool
+		wantCode    int
+		wantStored  bool
+		wantHasExif bool
+	}
+
+	tests := []tType{
+		// Scrubbing fails on the pointer to the Exif IFD beyond the end of the data
+		{
+			file:       "fixtures/dangling-ifd.jpg",
+			stripOnErr: true,
+			abortOnErr: true,
+			wantCode:   http.StatusOK,
+			wantStored: true,
+		},
+		{
+			file:       "fixtures/dangling-ifd.jpg",
+			stripOnErr: false,
+			abortOnErr: true,
+			wantCode:   http.StatusInternalServerError,
+		},
+		{
+			file:        "fixtures/dangling-ifd.jpg",
+			stripOnErr:  false,
+			abortOnErr:  false,
+			wantCode:    http.StatusOK,
+			wantStored:  true,
+			wantHasExif: true,
+		},
+		// Stripping fails as well
+		{
+			file:       truncatedFile,
+			stripOnErr: true,
+			abortOnErr: true,
+			wantCode:   http.StatusInternalServerError,
+		},
+		{
+			file:        truncatedFile,
+			stripOnErr:  true,
+			abortOnErr:  false,
+			wantCode:    http.StatusOK,
+			wantStored:  true,
+			wantHasExif: true,
+		},
+		// Not parseable as a JPEG file, but must not pass as unknown file type
+		{
+			file:       noEoiFile,
+			stripOnErr: true,
+			abortOnErr: true,
+			wantCode:   http.StatusOK,
+			wantStored: true,
+		},
+		{
+			file:       noEoiFile,
+			stripOnErr: false,
+			abortOnErr: true,
+			wantCode:   http.StatusInternalServerError,
+		},
+		// Unknown file types are stored as they are, regardless of the error settings
+		{
+			file:        textFile,
+			stripOnErr:  true,
+			abortOnErr:  true,
+			wantCode:    http.StatusOK,
+			wantStored:  true,
+			wantHasExif: true,
+		},
+		// Scrubbing succeeds but verification of the result fails on the second EXIF segment
+		{
+			file:       "fixtures/duplicate-exif.jpg",
+			stripOnErr: true,
+			abortOnErr: true,
+			wantCode:   http.StatusOK,
+			wantStored: true,
+		},
+		{
+			file:       "fixtures/duplicate-exif.jpg",
+			stripOnErr: false,
+			abortOnErr: true,
+			wantCode:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		config, err := ConfigFromFile("example.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config.FileDir = t.TempDir() + "/"
+		config.ExifStripOnError = test.stripOnErr
+		config.ExifAbortOnError = test.abortOnErr
+
+		handler := uploadHandler{live: newReloadableConfig(config)}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newFileRequest(t, http.MethodPost, "/upload", test.file))
+		if w.Code != test.wantCode {
+			t.Errorf(
+				"%s (strip: %t, abort: %t): have code %d, want %d",
+				test.file,
+				test.stripOnErr,
+				test.abortOnErr,
+				w.Code,
+				test.wantCode,
+			)
+			continue
+		}
+
+		entries, err := os.ReadDir(config.FileDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !test.wantStored {
+			assertEqual(len(entries), 0, t)
+			continue
+		}
+		if len(entries) != 1 {
+			t.Errorf("%s: have %d stored files, want 1", test.file, len(entries))
+			continue
+		}
+
+		storedData, err := os.ReadFile(filepath.Join(config.FileDir, entries[0].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasExif := bytes.Contains(storedData, []byte("Exif\x00\x00"))
+		if hasExif != test.wantHasExif {
+			t.Errorf("%s: stored file contains EXIF: %t, want %t", test.file, hasExif, test.wantHasExif)
+		}
+	}
+}
+
+func TestUploadHandlerLimitsTrailingData(t *testing.T) {
+	config, err := ConfigFromFile("example.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.FileDir = t.TempDir() + "/"
+	config.ScrubExif = false
+	handler := uploadHandler{live: newReloadableConfig(config)}
+
+	r := newFileRequest(t, http.MethodPost, "/upload", "fixtures/gps.png")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file and the multipart framing fit, the epilogue after the closing boundary does not
+	config.MaxUploadSize = len(body) + 100
+	body = append(body, bytes.Repeat([]byte("x"), 200)...)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assertEqual(w.Code, http.StatusRequestEntityTooLarge, t)
+	if strings.Contains(w.Body.String(), "internal server error") {
+		t.Errorf("have body %q, want a single error", w.Body.String())
+	}
+
+	entries, err := os.ReadDir(config.FileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 0, t)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+// A config value jaf cannot work with
+type ConfigProblem struct {
+	// Where the value was set, e.g., "jaf.conf:3", "environment variable JAF_PORT" or
+	// "default value"
+	Source string
+	// Config key the value belongs to, e.g., "LinkPrefix"
+	Key string
+	Err error
+}
+
+func (problem ConfigProblem) Error() string {
+	return fmt.Sprintf("%s: %s: %s", problem.Source, problem.Key, problem.Err)
+}
+
+func (problem ConfigProblem) Unwrap() error {
+	return problem.Err
+}
+
+// Every problem found in a config, in the order they were found
+type ConfigErrors []ConfigProblem
+
+func (errs ConfigErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problems:", len(errs))
+	for _, problem := range errs {
+		b.WriteString("\n\t")
+		b.WriteString(problem.Error())
+	}
+
+	return b.String()
+}
+
+// Checks the config for values jaf cannot work with, including values that could not be applied
+// while loading the config. Returns ConfigErrors listing every problem found.
+func (config *Config) Validate() error {
+	return config.validate(true)
+}
+
+// Like Validate, but only checks whether the FileDir exists and is writable if `checkFileDir` is
+// set. Commands that do not store uploads do not need a FileDir.
+func (config *Config) validate(checkFileDir bool) error {
+	problems := append(ConfigErrors{}, config.problems...)
+	report := func(key string, err error) {
+		problems = append(problems, ConfigProblem{
+			Source: config.sourceOf(key),
+			Key:    key,
+			Err:    err,
+		})
+	}
+
+	if config.LinkLength < 1 {
+		report("LinkLength", errors.Errorf("must be at least 1, got %d", config.LinkLength))
+	}
+
+	if err := checkBindAddress(config.BindAddress); err != nil {
+		report("BindAddress", err)
+	}
+
+	if config.Listen == ListenModeUnix && config.SocketPath == "" {
+		report("SocketPath", errors.Errorf("must be set if Listen is \"%s\"", ListenModeUnix))
+	}
+
+	if _, _, err := parseSocketOwner(config.SocketOwner); err != nil {
+		report("SocketOwner", err)
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		report("TLSKeyFile", errors.New("TLSCertFile and TLSKeyFile must be set together"))
+	}
+
+	if config.TLSRedirectPort != 0 && config.TLSCertFile == "" {
+		report("TLSRedirectPort", errors.New("requires TLSCertFile and TLSKeyFile"))
+	} else if config.TLSRedirectPort != 0 && config.Listen == ListenModeTcp &&
+		config.TLSRedirectPort == config.Port {
+		report("TLSRedirectPort", errors.Errorf("must differ from Port, got %d", config.Port))
+	}
+
+	if config.MaxHeaderBytes < 0 {
+		report("MaxHeaderBytes", errors.Errorf("must not be negative, got %d", config.MaxHeaderBytes))
+	}
+
+	if config.MaxUploadSize < 0 {
+		report("MaxUploadSize", errors.Errorf("must not be negative, got %d", config.MaxUploadSize))
+	}
+
+	if config.ScrubExif && config.ExifCoarsenGps && !newExifScrubber(config).AllowsGpsCoordinates() {
+		// Coarsening only applies to coordinates the allow/deny rules keep
+		if config.ExifMode == exifscrubber.ModeDenylist {
+			report("ExifCoarsenGps", errors.New(
+				"has no effect since ExifDeniedIds or ExifDeniedPaths deny the GPS coordinates"))
+		} else {
+			report("ExifCoarsenGps", errors.New(
+				"has no effect since ExifAllowedIds and ExifAllowedPaths do not allow the GPS "+
+					"coordinates, e.g., add the \"gps\" preset to ExifAllowedPaths"))
+		}
+	}
+
+	if err := checkLinkPrefix(config.LinkPrefix); err != nil {
+		report("LinkPrefix", err)
+	}
+
+	if !strings.HasSuffix(config.FileDir, "/") {
+		// Uploads would be saved next to the directory instead of inside it
+		report("FileDir", errors.Errorf("must end with a slash, e.g., \"%s/\"", config.FileDir))
+	} else if checkFileDir {
+		if err := checkWritableDir(config.FileDir); err != nil {
+			report("FileDir", err)
+		}
+	}
+
+	// Profiles inherit values from the base config, so do not report the same problem twice
+	reported := make(map[string]bool, len(problems))
+	for _, problem := range problems {
+		reported[problem.Error()] = true
+	}
+
+	for _, profile := range config.Profiles {
+		profileProblems, _ := profile.Config.validate(checkFileDir).(ConfigErrors)
+		for _, problem := range profileProblems {
+			if !reported[problem.Error()] {
+				reported[problem.Error()] = true
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// Returns where the value of `key` was set
+func (config *Config) sourceOf(key string) string {
+	source, found := config.sources[key]
+	if !found {
+		return "default value"
+	}
+
+	return source
+}
+
+func checkBindAddress(bindAddress string) error {
+	host := trimBrackets(bindAddress)
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		// Only IPv6 addresses may contain colons
+		return errors.Errorf(
+			"must be an IP address or host name without port, got \"%s\"",
+			bindAddress,
+		)
+	}
+
+	return nil
+}
+
+func checkLinkPrefix(linkPrefix string) error {
+	parsed, err := url.Parse(linkPrefix)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.Errorf(
+			"must be an http or https URL, e.g., \"https://jaf.example.com/\", got \"%s\"",
+			linkPrefix,
+		)
+	}
+
+	return nil
+}
+
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("directory does not exist")
+	} else if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	// Permission bits do not tell the whole story (e.g., read-only mounts), so actually try
+	file, err := os.CreateTemp(dir, ".jaf-check-*")
+	if err != nil {
+		return errors.Errorf("directory is not writable: %s", err)
+	}
+
+	file.Close()
+	return os.Remove(file.Name())
+}
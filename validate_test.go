@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leon-richardt/jaf/exifscrubber"
+)
+
+func TestValidate(t *testing.T) {
+	fileDir := t.TempDir() + "/"
+	path := filepath.Join(t.TempDir(), "jaf.conf")
+	err := os.WriteFile(path, []byte("Port: 8080\nFileDir: "+fileDir+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Validation must not leave anything behind in the FileDir
+	entries, err := os.ReadDir(fileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(entries), 0, t)
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jaf.conf")
+	err := os.WriteFile(path, []byte(
+		"Port: 0\n"+
+			"# a comment\n"+
+			"LinkPrefix: jaf.example.com\n"+
+			"FileDir: /var/www/jaf\n"+
+			"LinkLength: 0\n"+
+			"ExifAllowedIds: 0x0112 orientation\n"+
+			"Colour: blue\n",
+	), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Validate()
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("have error %v, want ConfigErrors", err)
+	}
+
+	type tType struct {
+		source string
+		key    string
+	}
+
+	want := []tType{
+		{source: path + ":1", key: "Port"},
+		{source: path + ":6", key: "ExifAllowedIds"},
+		{source: path + ":7", key: "Colour"},
+		{source: path + ":5", key: "LinkLength"},
+		{source: path + ":3", key: "LinkPrefix"},
+		{source: path + ":4", key: "FileDir"},
+	}
+
+	have := make([]tType, 0, len(problems))
+	for _, problem := range problems {
+		have = append(have, tType{source: problem.Source, key: problem.Key})
+	}
+	assertEqualSlice(have, want, t)
+}
+
+func TestValidateFileDir(t *testing.T) {
+	config := defaultConfig()
+	config.FileDir = filepath.Join(t.TempDir(), "missing") + "/"
+	config.sources["FileDir"] = "flag -file-dir"
+
+	err := config.Validate()
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 {
+		t.Fatalf("have error %v, want a single problem", err)
+	}
+	assertEqual(problems[0].Source, "flag -file-dir", t)
+	assertEqual(problems[0].Key, "FileDir", t)
+
+	// The FileDir is only needed by commands storing files
+	if err := config.validate(false); err != nil {
+		t.Error(err)
+	}
+
+	config.FileDir = filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(config.FileDir, []byte{}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.FileDir += "/"
+	if err := config.Validate(); err == nil {
+		t.Error("file accepted as FileDir")
+	}
+}
+
+func TestValidateGpsCoarsening(t *testing.T) {
+	type tType struct {
+		mode         exifscrubber.Mode
+		allowedPaths []string
+		deniedPaths  []string
+		wantProblem  bool
+	}
+
+	tests := []tType{
+		{mode: exifscrubber.ModeAllowlist, allowedPaths: []string{}, wantProblem: true},
+		{mode: exifscrubber.ModeAllowlist, allowedPaths: []string{"IFD/GPSInfo/**"}},
+		{mode: exifscrubber.ModeDenylist, deniedPaths: []string{}},
+		{mode: exifscrubber.ModeDenylist, deniedPaths: []string{"IFD/GPSInfo/**"}, wantProblem: true},
+		// Coarsening still applies to the longitude
+		{mode: exifscrubber.ModeDenylist, deniedPaths: []string{"IFD/GPSInfo/GPSLatitude"}},
+	}
+
+	for _, test := range tests {
+		config := defaultConfig()
+		config.FileDir = t.TempDir() + "/"
+		config.ExifCoarsenGps = true
+		config.ExifMode = test.mode
+		config.ExifAllowedPaths = test.allowedPaths
+		config.ExifDeniedPaths = test.deniedPaths
+
+		err := config.Validate()
+
+		var problems ConfigErrors
+		if test.wantProblem {
+			if !errors.As(err, &problems) || len(problems) != 1 {
+				t.Fatalf("%s %v %v: have error %v, want a single problem", test.mode,
+					test.allowedPaths, test.deniedPaths, err)
+			}
+			assertEqual(problems[0].Key, "ExifCoarsenGps", t)
+		} else if err != nil {
+			t.Errorf("%s %v %v: %s", test.mode, test.allowedPaths, test.deniedPaths, err)
+		}
+	}
+}